@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// account is one whatshttp client (work phone, personal phone...) with its
+// own backend and contact names
+type account struct {
+	name          string
+	clientID      string
+	backendURL    string
+	backend       Backend
+	id_to_name    map[string]string
+	chat_states   map[string]string        // last typing/presence state of each chat
+	unread        int                      // messages received while another account was open
+	input_history []string                 // sent messages, oldest first
+	drafts        map[string]string        // unsent input of each chat
+	unsaved       map[string]bool          // chats whose draft changed since saveDrafts
+	draftFile     *draftFile               // where drafts are kept, nil to keep them in memory
+	previews      map[string]*imagePreview // thumbnails of image messages by id
+}
+
+// new_account builds an account, caching its backend in st and keeping its
+// drafts in drafts unless they are nil
+func new_account(cfg config, ac accountConfig, st *store, drafts *draftFile) *account {
+	acc := &account{}
+	acc.name = ac.Name
+	acc.clientID = ac.ClientID
+	acc.backendURL = ac.BackendURL
+	acc.id_to_name = make(map[string]string)
+	acc.chat_states = make(map[string]string)
+	acc.drafts = make(map[string]string)
+	acc.unsaved = make(map[string]bool)
+	acc.previews = make(map[string]*imagePreview)
+	acc.backend = newBackend(cfg, ac)
+	if st != nil {
+		acc.backend = newCachingBackend(acc.backend, st, ac.ClientID)
+	}
+	if drafts != nil {
+		acc.draftFile = drafts
+		acc.drafts = drafts.load(ac.ClientID)
+	}
+	return acc
+}
+
+// accountByClientID returns the account a webhook event belongs to, events
+// without a client id go to the first account
+func (a *app) accountByClientID(clientID string) *account {
+	for _, acc := range a.accounts {
+		if acc.clientID == clientID {
+			return acc
+		}
+	}
+	if clientID == "" {
+		return a.accounts[0]
+	}
+	return nil
+}
+
+type accounts_page struct {
+	selected  int
+	container *pageContainer
+}
+
+func new_accounts_page(container *pageContainer) accounts_page {
+	if container == nil {
+		panic("passed nil container")
+	}
+
+	ap := accounts_page{}
+	ap.container = container
+	for i, acc := range container.app.accounts {
+		if acc == container.app.current {
+			ap.selected = i
+		}
+	}
+	return ap
+}
+
+func (ap accounts_page) Init() tea.Cmd {
+	return nil
+}
+
+func (ap *accounts_page) registerLuaFuncs() {
+	L := ap.container.app.luaState
+	L.SetGlobal("account_scroll_up", L.NewFunction(func(L *lua.LState) int {
+		if ap.selected > 0 {
+			ap.selected--
+		}
+		return 0
+	}))
+	L.SetGlobal("account_scroll_down", L.NewFunction(func(L *lua.LState) int {
+		if ap.selected < len(ap.container.app.accounts)-1 {
+			ap.selected++
+		}
+		return 0
+	}))
+	L.SetGlobal("account_select", L.NewFunction(func(L *lua.LState) int {
+		acc := ap.container.app.accounts[ap.selected]
+		acc.unread = 0
+		ap.container.app.current = acc
+		ap.container.app.luaReturn = "go_account"
+		return 0
+	}))
+	L.SetGlobal("account_escape", L.NewFunction(func(L *lua.LState) int {
+		ap.container.app.luaReturn = "go_back"
+		return 0
+	}))
+}
+
+func (ap accounts_page) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		ap.registerLuaFuncs()
+		ap.container.app.luaReturn = ""
+		L := ap.container.app.luaState
+
+		err := L.DoString(fmt.Sprintf(`
+			local key = %q
+			local f = (account_keybinds or {})[key]
+			if type(f) == "function" then
+				f()
+			end
+		`, msg.String()))
+		if err != nil {
+			fmt.Println("Lua error:", err)
+		}
+
+		switch ap.container.app.luaReturn {
+		case "go_account":
+			// the history belongs to the previous account
+			cp := new_chats_page(ap.container)
+			ap.container.commands = append(ap.container.commands, getChats(ap.container.app.current.backend))
+			return ap.container.reset(cp), nil
+		case "go_back":
+			return ap.container.pop(ap.container.chatsFallback), nil
+		}
+	}
+	return ap, nil
+}
+
+func (ap accounts_page) View() string {
+	var b strings.Builder
+	b.WriteString("Accounts:\n\n")
+	for i, acc := range ap.container.app.accounts {
+		line := acc.name
+		if acc.name != acc.clientID {
+			line += " (" + acc.clientID + ")"
+		}
+		if acc.unread > 0 {
+			line += fmt.Sprintf(" [%d new]", acc.unread)
+		}
+		if acc == ap.container.app.current {
+			line += " *"
+		}
+		if i == ap.selected {
+			b.WriteString(fmt.Sprintf("> %s\n", styles["selectedStyle"].Render(line)))
+		} else {
+			b.WriteString(fmt.Sprintf("  %s\n", styles["unselectedStyle"].Render(line)))
+		}
+	}
+	return b.String()
+}
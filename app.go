@@ -4,6 +4,7 @@ import (
 	"os"
 	"fmt"
 	tea "github.com/charmbracelet/bubbletea"
+	zone "github.com/lrstanley/bubblezone"
 	lua "github.com/yuin/gopher-lua"
 	"golang.org/x/term"
 )
@@ -14,6 +15,10 @@ type pageContainer struct {
 	page 	tea.Model
 	commands []tea.Cmd
 	app		*app
+	split	*split_page // last split page, to go back to in the split layout
+	stack	[]tea.Model // pages under the current one, see push and pop
+	request	*navRequest
+	mouse	*tea.MouseMsg // the mouse event being handled, for lua
 }
 
 func new_page_container(page tea.Model, app *app) *pageContainer {
@@ -26,34 +31,50 @@ func new_page_container(page tea.Model, app *app) *pageContainer {
 
 func (pc * pageContainer) update (msg tea.Msg) {
 	pc.commands = make([]tea.Cmd, 0)
+	if mouse, ok := msg.(tea.MouseMsg); ok {
+		pc.mouse = &mouse
+		defer func() { pc.mouse = nil }()
+	}
 	p, _ := pc.page.Update(msg);
-	pc.page = p
+	pc.page = pc.arrange(pc.navigate(p))
 	pc.page.Init()
 }
 
 type app struct {
 	page_conatiner	*pageContainer
-	id_to_name		map[string]string
+	accounts		[]*account
+	current			*account
 	width           int
 	height          int
 	flashMsg        string       // message to flash in bottom bar
 	flashCount      int          // counter for flash animation
 	luaState	*lua.LState 
 	luaReturn	string
+	config		config
+	layout		layout
+	forwards	[]forwardTarget // chats the last forward went to
+	attachDir	string // where the file picker was last used
 }
 
-func initialApp() *app {
+// initialApp starts on the login page when some accounts still need their
+// QR code scanned, and on the chats otherwise
+func initialApp(cfg config, accounts []*account, notReady []*account) *app {
 	width, height, _ := term.GetSize(int(os.Stdout.Fd()))
 	a  := &app{}
 	pc := new_page_container(loading_page{}, a);
-	lp := new_loading_page(pc)
-	pc.page = lp
+	if len(notReady) > 0 {
+		pc.page = new_login_page(pc, notReady)
+	} else {
+		pc.page = new_loading_page(pc)
+	}
 	a.page_conatiner = pc
 	a.width = width
 	a.height = height
 	a.flashCount = 0
 	a.flashMsg = ""
-	a.id_to_name = make(map[string]string)
+	a.accounts = accounts
+	a.current = accounts[0]
+	a.config = cfg
 	a.luaState = lua.NewState()
 	lua.OpenIo(a.luaState)
 	lua.OpenOs(a.luaState)
@@ -65,10 +86,17 @@ func initialApp() *app {
 	if err := a.luaState.DoFile(luaPath + "/init.lua"); err != nil {
 		panic(fmt.Errorf("error loading init.lua: %w", err))
 	}
+	if err := a.luaState.DoString(defaultFallbackLua); err != nil {
+		panic(fmt.Errorf("error loading default keybinds: %w", err))
+	}
 	if err := a.luaState.DoFile(luaPath + "/colors.lua"); err != nil {
 		panic(fmt.Errorf("error loading colors.lua: %w", err))
 	}
 	setup_styles(a.luaState)
+	registerNavigationFuncs(a.luaState, pc)
+	registerMouseFuncs(a.luaState, pc)
+	zone.NewGlobal()
+	a.layout = readLayout(a.luaState)
 
 	return a
 }
@@ -90,6 +118,45 @@ func (m app) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.flashCount = msg.count
 		m.flashMsg = msg.msg
 		cmds = append(cmds, flashTick())
+	case accountEvent:
+		acc := m.accountByClientID(msg.eventClientID())
+		if acc == nil {
+			return m, nil
+		}
+		runEventHook(m.luaState, msg)
+
+		switch msg := msg.(type) {
+		case chatStateMsg:
+			acc.chat_states[msg.ChatID] = msg.State
+		case disconnectedMsg:
+			m.flashMsg = "[" + acc.name + "] DISCONNECTED " + msg.Reason
+			m.flashCount = 6
+			if acc == m.current {
+				m.page_conatiner.page = m.page_conatiner.reset(new_login_page(m.page_conatiner, []*account{acc}))
+			}
+			return m, flashTick()
+		}
+
+		// events of the accounts not on screen only get flashed
+		if acc != m.current {
+			if hook, ok := msg.(webhookMsg); ok {
+				acc.unread++
+				m.flashMsg = "[" + acc.name + "] MSG FROM " + hook.Chat.Name
+				m.flashCount = 6
+				return m, flashTick()
+			}
+			return m, nil
+		}
+	case forwardResultMsg:
+		cmds = append(cmds, m.forwardDone(msg))
+	case downloadProgressMsg:
+		cmds = append(cmds, m.downloadProgress(msg))
+	case previewLoadedMsg:
+		m.previewLoaded(msg)
+	case externalEditMsg:
+		if m.keepExternalEdit(msg) {
+			return m, nil
+		}
 	case flashTickMsg:
 		if m.flashCount > 0 {
 			m.flashCount--
@@ -107,6 +174,6 @@ func (m app) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 func (m app) View() string {
 	m.page_conatiner.app = &m
-	return m.page_conatiner.page.View()
+	return zone.Scan(m.page_conatiner.page.View())
 }
 
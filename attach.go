@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/ansi"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// attachFilter narrows the files the picker lists by their MIME type
+type attachFilter struct {
+	name  string
+	match func(mimeType string) bool
+}
+
+var attachFilters = []attachFilter{
+	{"all", func(string) bool { return true }},
+	{"images", func(t string) bool { return strings.HasPrefix(t, "image/") }},
+	{"videos", func(t string) bool { return strings.HasPrefix(t, "video/") }},
+	{"audio", func(t string) bool { return strings.HasPrefix(t, "audio/") }},
+	{"documents", func(t string) bool { return strings.HasPrefix(t, "application/") || strings.HasPrefix(t, "text/") }},
+}
+
+// fileEntry is a file or directory listed by the picker
+type fileEntry struct {
+	name  string
+	isDir bool
+	size  int64
+}
+
+// filePicker is the overlay to pick files to attach, they are marked with
+// space and attached together
+type filePicker struct {
+	dir     string
+	entries []fileEntry
+	idx     int
+	filter  int // index in attachFilters
+	marked  []string
+	sniffed map[string]string // MIME type of the files looked at
+	err     error             // reading dir failed
+}
+
+// newFilePicker opens the picker on dir, the working directory if empty
+func newFilePicker(dir string) *filePicker {
+	if dir == "" {
+		dir, _ = os.Getwd()
+	}
+	fp := &filePicker{sniffed: make(map[string]string)}
+	fp.open(dir)
+	return fp
+}
+
+// open lists dir, directories first, hiding dotfiles and the files the
+// filter leaves out
+func (fp *filePicker) open(dir string) {
+	fp.dir = filepath.Clean(dir)
+	fp.idx = 0
+	fp.entries = nil
+	if filepath.Dir(fp.dir) != fp.dir {
+		fp.entries = append(fp.entries, fileEntry{name: "..", isDir: true})
+	}
+	dirEntries, err := os.ReadDir(fp.dir)
+	fp.err = err
+	var dirs, files []fileEntry
+	for _, e := range dirEntries {
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		if info.IsDir() {
+			dirs = append(dirs, fileEntry{name: e.Name(), isDir: true})
+			continue
+		}
+		if !info.Mode().IsRegular() || !attachFilters[fp.filter].match(mime.TypeByExtension(filepath.Ext(e.Name()))) {
+			continue
+		}
+		files = append(files, fileEntry{name: e.Name(), size: info.Size()})
+	}
+	sort.Slice(dirs, func(i, j int) bool { return strings.ToLower(dirs[i].name) < strings.ToLower(dirs[j].name) })
+	sort.Slice(files, func(i, j int) bool { return strings.ToLower(files[i].name) < strings.ToLower(files[j].name) })
+	fp.entries = append(fp.entries, dirs...)
+	fp.entries = append(fp.entries, files...)
+}
+
+func (fp *filePicker) path(e fileEntry) string {
+	return filepath.Join(fp.dir, e.name)
+}
+
+func (fp *filePicker) isMarked(path string) bool {
+	for _, p := range fp.marked {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+// toggle marks or unmarks the highlighted file
+func (fp *filePicker) toggle() {
+	if fp.idx >= len(fp.entries) || fp.entries[fp.idx].isDir {
+		return
+	}
+	path := fp.path(fp.entries[fp.idx])
+	for i, p := range fp.marked {
+		if p == path {
+			fp.marked = append(fp.marked[:i:i], fp.marked[i+1:]...)
+			return
+		}
+	}
+	fp.marked = append(fp.marked, path)
+}
+
+// contentType is the MIME type of a file sniffed from its content, like the
+// backend does before sending it
+func (fp *filePicker) contentType(path string) string {
+	if t, ok := fp.sniffed[path]; ok {
+		return t
+	}
+	t := "unknown type"
+	if f, err := os.Open(path); err == nil {
+		if detected, err := detectContentType(f); err == nil {
+			t = detected
+		}
+		f.Close()
+	}
+	fp.sniffed[path] = t
+	return t
+}
+
+// overlayPicker draws the file picker centered over the lines of the
+// messages
+func (mp messages_page) overlayPicker(lines []string) []string {
+	fp := mp.attaching
+	width := max(20, min(70, mp.container.app.width-8))
+	rows := max(3, len(lines)-10)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Attach (%s): %s\n", attachFilters[fp.filter].name, ansi.Truncate(fp.dir, width-len(attachFilters[fp.filter].name)-12, "..."))
+	start := max(0, min(fp.idx-rows/2, len(fp.entries)-rows))
+	for i := start; i < min(start+rows, len(fp.entries)); i++ {
+		e := fp.entries[i]
+		mark := "    "
+		if fp.isMarked(fp.path(e)) {
+			mark = "[x] "
+		}
+		name, size := e.name, ""
+		if e.isDir {
+			name += "/"
+		} else {
+			size = formatSize(e.size)
+		}
+		name = ansi.Truncate(name, width-len(mark)-len(size)-3, "...")
+		row := mark + name + strings.Repeat(" ", max(1, width-len(mark)-ansi.StringWidth(name)-len(size)-2)) + size
+		b.WriteString("\n")
+		if i == fp.idx {
+			b.WriteString(styles["selectedStyle"].Render("> " + row))
+		} else {
+			b.WriteString("  " + row)
+		}
+	}
+	if fp.err != nil {
+		b.WriteString("\n" + fp.err.Error())
+	} else if len(fp.entries) == 0 {
+		b.WriteString("\nno " + attachFilters[fp.filter].name + " here")
+	}
+
+	b.WriteString("\n\n")
+	if fp.idx < len(fp.entries) && !fp.entries[fp.idx].isDir {
+		e := fp.entries[fp.idx]
+		b.WriteString(fp.contentType(fp.path(e)) + ", " + formatSize(e.size))
+	}
+	if len(fp.marked) > 0 {
+		fmt.Fprintf(&b, " | %d marked", len(fp.marked))
+	}
+	b.WriteString("\nspace marks, enter attaches, tab filters, backspace goes up")
+	return mp.overlayBox(lines, b.String())
+}
+
+// attach closes the picker adding the marked files, or the highlighted
+// one, to the files sent with the next message
+func (mp *messages_page) attach() {
+	fp := mp.attaching
+	if fp.idx < len(fp.entries) {
+		if e := fp.entries[fp.idx]; e.isDir {
+			fp.open(fp.path(e))
+			return
+		} else if len(fp.marked) == 0 {
+			fp.marked = append(fp.marked, fp.path(e))
+		}
+	}
+	if len(fp.marked) == 0 {
+		return
+	}
+	mp.attachments = append(mp.attachments, fp.marked...)
+	mp.container.app.attachDir = fp.dir
+	mp.attaching = nil
+	mp.inInput = true
+}
+
+// attachmentsBar names the files attached to the next message
+func (mp messages_page) attachmentsBar() string {
+	names := make([]string, len(mp.attachments))
+	for i, path := range mp.attachments {
+		names[i] = filepath.Base(path)
+	}
+	text := "the text is the caption"
+	if len(names) > 1 {
+		text = "the text is sent after them"
+	}
+	return fmt.Sprintf(" Attaching %s (Enter sends, %s, Esc drops them)", strings.Join(names, ", "), text)
+}
+
+// sendAttachments sends files one after the other so they arrive in order,
+// the quote goes with the first. A single file gets the caption, after
+// several it is sent as its own message so it is not tied to one of them
+func sendAttachments(b Backend, chatID string, paths []string, caption, responseToID string) tea.Cmd {
+	return func() tea.Msg {
+		fileCaption := ""
+		if len(paths) == 1 {
+			fileCaption = caption
+		}
+		for i, path := range paths {
+			if i > 0 {
+				responseToID = ""
+			}
+			if err := b.SendMedia(chatID, path, fileCaption, responseToID); err != nil {
+				return updateFlashMsg{msg: "Could not send " + filepath.Base(path) + ": " + err.Error(), count: 6}
+			}
+		}
+		if len(paths) > 1 && strings.TrimSpace(caption) != "" {
+			if err := b.SendMessage(chatID, caption, ""); err != nil {
+				return updateFlashMsg{msg: "Could not send the text: " + err.Error(), count: 6}
+			}
+		}
+		return getMessages(b, chatID)()
+	}
+}
+
+// registerAttachFuncs exposes the file picker to lua
+func (mp *messages_page) registerAttachFuncs() {
+	L := mp.container.app.luaState
+
+	L.SetGlobal("attach_files", L.NewFunction(func(L *lua.LState) int {
+		if mp.editingMsg != "" {
+			return 0
+		}
+		mp.attaching = newFilePicker(L.OptString(1, mp.container.app.attachDir))
+		return 0
+	}))
+	L.SetGlobal("attach_next", L.NewFunction(func(L *lua.LState) int {
+		if mp.attaching != nil && mp.attaching.idx < len(mp.attaching.entries)-1 {
+			mp.attaching.idx++
+		}
+		return 0
+	}))
+	L.SetGlobal("attach_prev", L.NewFunction(func(L *lua.LState) int {
+		if mp.attaching != nil && mp.attaching.idx > 0 {
+			mp.attaching.idx--
+		}
+		return 0
+	}))
+	L.SetGlobal("attach_mark", L.NewFunction(func(L *lua.LState) int {
+		if mp.attaching != nil {
+			mp.attaching.toggle()
+		}
+		return 0
+	}))
+	L.SetGlobal("attach_up", L.NewFunction(func(L *lua.LState) int {
+		if mp.attaching != nil {
+			mp.attaching.open(filepath.Dir(mp.attaching.dir))
+		}
+		return 0
+	}))
+	L.SetGlobal("attach_filter", L.NewFunction(func(L *lua.LState) int {
+		if mp.attaching != nil {
+			mp.attaching.filter = (mp.attaching.filter + 1) % len(attachFilters)
+			mp.attaching.open(mp.attaching.dir)
+		}
+		return 0
+	}))
+	L.SetGlobal("attach_open", L.NewFunction(func(L *lua.LState) int {
+		if mp.attaching != nil {
+			mp.attach()
+		}
+		return 0
+	}))
+}
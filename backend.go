@@ -0,0 +1,73 @@
+package main
+
+import "io"
+
+// Backend is everything whats-cli needs from a WhatsApp server. The pages only
+// talk to this interface, so whatshttp is just one adapter and another
+// transport (or the in-memory stub) can be plugged in without touching them.
+type Backend interface {
+	// Client returns the state of the WhatsApp session behind the backend
+	Client() (client, error)
+	// Login creates or restarts the WhatsApp session, posting events to webHook.
+	// The QR code to scan then shows up in Client() until it becomes ready
+	Login(webHook string) error
+
+	Chats() ([]Chat, error)
+	// Messages returns up to limit messages of a chat, oldest first. With an
+	// empty before they are the latest ones, otherwise the ones sent before
+	// the message with that id
+	Messages(chatID, before string, limit int) ([]message, error)
+
+	// SendMessage sends text to a chat, quoting responseToID when it is not empty
+	SendMessage(chatID, text, responseToID string) error
+	// SendMedia sends the file at mediaPath with an optional caption and quote
+	SendMedia(chatID, mediaPath, caption, responseToID string) error
+	// DeleteMessage deletes a message of a chat, for everyone in it or only
+	// from our phone
+	DeleteMessage(chatID, msgID string, everyone bool) error
+	ForwardMessage(msgID, toChatID string) error
+	// EditMessage replaces the text of one of our messages
+	EditMessage(msgID, text string) error
+	// ReactMessage puts our emoji on a message, an empty emoji removes it
+	ReactMessage(msgID, emoji string) error
+
+	// MediaURL returns where the media of a message can be opened from,
+	// or "" if the backend can't serve it
+	MediaURL(msgID string) string
+	// Media streams the media of a message, the caller closes it
+	Media(msgID string) (*mediaFile, error)
+}
+
+// mediaFile is the media of a message being read from the backend
+type mediaFile struct {
+	io.ReadCloser
+	mimeType string
+	size     int64  // -1 when unknown
+	name     string // file name the sender gave it, "" when unknown
+}
+
+// pageBefore is Messages for backends that have the whole history at hand
+func pageBefore(msgs []message, before string, limit int) []message {
+	end := len(msgs)
+	if before != "" {
+		end = -1
+		for i, m := range msgs {
+			if m.MsgID == before {
+				end = i
+			}
+		}
+		if end == -1 {
+			return nil
+		}
+	}
+	return msgs[max(0, end-limit):end]
+}
+
+// client is the WhatsApp session state as reported by the backend
+type client struct {
+	ClientId string `json:"clientId"`
+	Name     string `json:"name"`
+	Ready    bool   `json:"ready"`
+	Qr       string `json:"qr"` // base64 image data
+	WebHook  string `json:"webHook"`
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryBackend is a Backend that keeps everything in memory. It is seeded
+// with a couple of fake chats so the TUI can be run without a whatshttp server.
+type memoryBackend struct {
+	mu       sync.Mutex
+	chats    []Chat
+	messages map[string][]message
+	media    map[string]memoryMedia // media of the messages that have it
+	nextID   int
+}
+
+type memoryMedia struct {
+	data     []byte
+	mimeType string
+	name     string
+}
+
+func newMemoryBackend() *memoryBackend {
+	mb := &memoryBackend{messages: make(map[string][]message), media: make(map[string]memoryMedia)}
+
+	mb.chats = []Chat{
+		{ID: "5500000000001@c.us", Name: "Alice"},
+		{ID: "5500000000002@c.us", Name: "Bob"},
+		{ID: "120000000000000001@g.us", Name: "Stub Group", IsGroup: true},
+	}
+	mb.receive("5500000000001@c.us", "5500000000001@c.us", "hey, this is the in-memory backend")
+	mb.receive("5500000000002@c.us", "5500000000002@c.us", "nothing here leaves your machine")
+	mb.receive("120000000000000001@g.us", "5500000000001@c.us", "hello group")
+	mb.receive("120000000000000001@g.us", "5500000000002@c.us", "hi alice")
+	return mb
+}
+
+// newMsg must be called with mu held
+func (mb *memoryBackend) newMsg(chatID string) message {
+	mb.nextID++
+	return message{
+		MsgID:     fmt.Sprintf("mem_%d", mb.nextID),
+		From:      chatID,
+		Type:      "chat",
+		Timestamp: time.Now().UTC(),
+		Info:      map[string]bool{"read": false, "delivered": false, "played": false},
+	}
+}
+
+// add must be called with mu held
+func (mb *memoryBackend) add(chatID string, msg message) {
+	mb.messages[chatID] = append(mb.messages[chatID], msg)
+	for i := range mb.chats {
+		if mb.chats[i].ID == chatID {
+			mb.chats[i].LastMessage = msg
+		}
+	}
+}
+
+func (mb *memoryBackend) receive(chatID, from, body string) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	msg := mb.newMsg(chatID)
+	msg.Body = body
+	if chatID != from {
+		msg.GroupFrom = from
+	}
+	mb.add(chatID, msg)
+}
+
+func (mb *memoryBackend) find(msgID string) (string, int) {
+	for chatID, msgs := range mb.messages {
+		for i, m := range msgs {
+			if m.MsgID == msgID {
+				return chatID, i
+			}
+		}
+	}
+	return "", -1
+}
+
+func (mb *memoryBackend) Client() (client, error) {
+	return client{ClientId: "memory", Name: "in-memory stub", Ready: true}, nil
+}
+
+func (mb *memoryBackend) Login(webHook string) error {
+	return nil
+}
+
+func (mb *memoryBackend) Chats() ([]Chat, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return append([]Chat(nil), mb.chats...), nil
+}
+
+func (mb *memoryBackend) Messages(chatID, before string, limit int) ([]message, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	return append([]message(nil), pageBefore(mb.messages[chatID], before, limit)...), nil
+}
+
+func (mb *memoryBackend) SendMessage(chatID, text, responseToID string) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	msg := mb.newMsg(chatID)
+	msg.FromMe = true
+	msg.Body = text
+	msg.ResponseToID = responseToID
+	msg.IsResponse = responseToID != ""
+	mb.add(chatID, msg)
+	return nil
+}
+
+func (mb *memoryBackend) SendMedia(chatID, mediaPath, caption, responseToID string) error {
+	data, err := os.ReadFile(mediaPath)
+	if err != nil {
+		return err
+	}
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	msg := mb.newMsg(chatID)
+	msg.FromMe = true
+	msg.HasMedia = true
+	msg.Type = "document"
+	if strings.HasPrefix(http.DetectContentType(data), "image/") {
+		msg.Type = "image"
+	}
+	msg.Body = caption
+	if caption == "" {
+		msg.Body = filepath.Base(mediaPath)
+	}
+	msg.ResponseToID = responseToID
+	msg.IsResponse = responseToID != ""
+	mb.media[msg.MsgID] = memoryMedia{data: data, mimeType: http.DetectContentType(data), name: filepath.Base(mediaPath)}
+	mb.add(chatID, msg)
+	return nil
+}
+
+func (mb *memoryBackend) DeleteMessage(chatID, msgID string, everyone bool) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	chatID, i := mb.find(msgID)
+	if i == -1 {
+		return fmt.Errorf("message %s not found", msgID)
+	}
+	if everyone {
+		mb.messages[chatID][i].revoke()
+		return nil
+	}
+	msgs := mb.messages[chatID]
+	mb.messages[chatID] = append(msgs[:i:i], msgs[i+1:]...)
+	return nil
+}
+
+func (mb *memoryBackend) ForwardMessage(msgID, toChatID string) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	chatID, i := mb.find(msgID)
+	if i == -1 {
+		return fmt.Errorf("message %s not found", msgID)
+	}
+	orig := mb.messages[chatID][i]
+	msg := mb.newMsg(toChatID)
+	msg.FromMe = true
+	msg.Body = orig.Body
+	msg.Type = orig.Type
+	msg.HasMedia = orig.HasMedia
+	msg.IsForwarded = true
+	if media, ok := mb.media[msgID]; ok {
+		mb.media[msg.MsgID] = media
+	}
+	mb.add(toChatID, msg)
+	return nil
+}
+
+func (mb *memoryBackend) EditMessage(msgID, text string) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	chatID, i := mb.find(msgID)
+	if i == -1 {
+		return fmt.Errorf("message %s not found", msgID)
+	}
+	mb.messages[chatID][i].edit(text)
+	return nil
+}
+
+func (mb *memoryBackend) ReactMessage(msgID, emoji string) error {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	chatID, i := mb.find(msgID)
+	if i == -1 {
+		return fmt.Errorf("message %s not found", msgID)
+	}
+	mb.messages[chatID][i].react("", emoji, true)
+	return nil
+}
+
+func (mb *memoryBackend) MediaURL(msgID string) string {
+	return ""
+}
+
+func (mb *memoryBackend) Media(msgID string) (*mediaFile, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	media, ok := mb.media[msgID]
+	if !ok {
+		return nil, fmt.Errorf("message %s has no media", msgID)
+	}
+	return &mediaFile{
+		ReadCloser: io.NopCloser(bytes.NewReader(media.data)),
+		mimeType:   media.mimeType,
+		size:       int64(len(media.data)),
+		name:       media.name,
+	}, nil
+}
+
+// SearchMessages scans every message, the stub is never big enough to need
+// an index
+func (mb *memoryBackend) SearchMessages(query string, names map[string]string, limit int) ([]searchResult, error) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	var results []searchResult
+	for _, chat := range mb.chats {
+		for _, m := range mb.messages[chat.ID] {
+			if r, ok := newSearchResult(terms, chat, m, names); ok {
+				results = append(results, r)
+			}
+		}
+	}
+	return rankResults(results, limit), nil
+}
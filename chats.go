@@ -1,11 +1,8 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
-	"net/http"
 	"strings"
-	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 	lua "github.com/yuin/gopher-lua"
@@ -30,9 +27,11 @@ type chats_page struct {
 	container    *pageContainer
 	forwarding   struct {
 		isForwarding bool
-		MsgID        string
+		MsgIDs       []string        // messages to forward, oldest first
+		marked       map[string]bool // chats to forward them to
 	}
-	lines []string
+	lines    []string
+	lineChat []int // the chat each of lines belongs to
 }
 
 type chatsLoadedMsg []Chat
@@ -56,6 +55,10 @@ func (cp chats_page) Init() tea.Cmd {
 func (cp *chats_page) registerLuaFuncs() {
 	L := cp.container.app.luaState
 	L.SetGlobal("chat_escape", L.NewFunction(func(L *lua.LState) int {
+		if cp.container.canPop() {
+			cp.container.app.luaReturn = "go_back"
+			return 0
+		}
 		cp.container.commands = append(cp.container.commands, tea.Quit)
 		return 0
 	}))
@@ -82,14 +85,59 @@ func (cp *chats_page) registerLuaFuncs() {
 		return 0
 	}))
 
-	L.SetGlobal("chat_select", L.NewFunction(func(L *lua.LState) int {
+	chatSelect := func(L *lua.LState) int {
+		if cp.selectedChat >= len(cp.chats) {
+			return 0
+		}
 		if cp.forwarding.isForwarding {
-			forwardMsgToChat(cp.chats[cp.selectedChat].ID, cp.forwarding.MsgID)
-			time.Sleep(2 * time.Second)
+			cp.forward()
+			// back to the chat the messages were forwarded from
+			cp.container.app.luaReturn = "go_back"
+			return 0
 		}
 
 		cp.container.app.luaReturn = "go_messages"
-		cp.container.commands = append(cp.container.commands, getMessages(cp.chats[cp.selectedChat].ID))
+		cp.container.commands = append(cp.container.commands, getMessages(cp.container.app.current.backend, cp.chats[cp.selectedChat].ID))
+		return 0
+	}
+	L.SetGlobal("chat_select", L.NewFunction(chatSelect))
+
+	L.SetGlobal("chat_mark", L.NewFunction(func(L *lua.LState) int {
+		if !cp.forwarding.isForwarding || cp.selectedChat >= len(cp.chats) {
+			return 0
+		}
+		id := cp.chats[cp.selectedChat].ID
+		if cp.forwarding.marked[id] {
+			delete(cp.forwarding.marked, id)
+		} else {
+			cp.forwarding.marked[id] = true
+		}
+		return 0
+	}))
+
+	L.SetGlobal("chat_click", L.NewFunction(func(L *lua.LState) int {
+		if cp.container.mouse == nil {
+			return 0
+		}
+		idx := cp.chatAt(*cp.container.mouse)
+		if idx == -1 {
+			return 0
+		}
+		// clicking the selected chat again opens it
+		if idx == cp.selectedChat {
+			return chatSelect(L)
+		}
+		cp.selectChat(idx)
+		return 0
+	}))
+
+	L.SetGlobal("open_accounts", L.NewFunction(func(L *lua.LState) int {
+		cp.container.app.luaReturn = "go_accounts"
+		return 0
+	}))
+
+	L.SetGlobal("open_search", L.NewFunction(func(L *lua.LState) int {
+		cp.container.app.luaReturn = "go_search"
 		return 0
 	}))
 
@@ -115,23 +163,43 @@ func (cp chats_page) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case chatsLoadedMsg:
 		for _, c := range msg {
-			cp.container.app.id_to_name[c.ID] = c.Name
+			cp.container.app.current.id_to_name[c.ID] = c.Name
 		}
 
+		// a refresh keeps the same chat selected, wherever it moved
+		selectedID := ""
+		if cp.selectedChat < len(cp.chats) {
+			selectedID = cp.chats[cp.selectedChat].ID
+		}
 		cp.chats = msg
-		cp.scrollOffset = 0 // Reset scroll when loading chats
+		cp.selectedChat = 0
+		cp.curr_line = 0
+		for i, c := range cp.chats {
+			if c.ID == selectedID {
+				cp.selectedChat = i
+				break
+			}
+			cp.curr_line += len(strings.Split(cp.renderChat(c, i), "\n"))
+		}
+		if cp.selectedChat == 0 {
+			cp.curr_line = 0
+		}
+		if cp.curr_line < cp.scrollOffset || cp.curr_line >= cp.scrollOffset+(cp.container.app.height-3) {
+			cp.scrollOffset = cp.curr_line
+		}
 		setTerminalTitle("Whats-CLI")
 		return cp, nil
-	case tea.KeyMsg:
+	case tea.KeyMsg, tea.MouseMsg:
 		cp.registerLuaFuncs()
 		cp.container.app.luaReturn = "" // Reset lua return
-		key := msg.String()
+		key := msg.(fmt.Stringer).String()
 
 		// Look for Lua keybind
 		L := cp.container.app.luaState
 
 		// Try to run keybinds[key]()
 		err := L.DoString(fmt.Sprintf(`
+			handled = false
 			local key = %q
 			local f = chat_keybinds[key]
 			if type(f) == "function" then
@@ -147,8 +215,14 @@ func (cp chats_page) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			switch cp.container.app.luaReturn {
 			case "go_messages":
 				mp := new_messages_page(cp.chats[cp.selectedChat], cp.container)
-				cp.container.commands = append(mp.container.commands, getMessages(cp.chats[cp.selectedChat].ID))
-				return mp, nil
+				cp.container.commands = append(mp.container.commands, getMessages(cp.container.app.current.backend, cp.chats[cp.selectedChat].ID))
+				return cp.container.push(cp, mp), nil
+			case "go_back":
+				return cp.container.pop(cp.container.chatsFallback), nil
+			case "go_accounts":
+				return cp.container.push(cp, new_accounts_page(cp.container)), nil
+			case "go_search":
+				return cp.container.push(cp, new_search_page(cp.container)), nil
 			}
 		}
 
@@ -157,8 +231,15 @@ func (cp chats_page) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case webhookMsg:
 		cp.container.app.flashMsg = "MSG FROM " + msg.Chat.Name
 		cp.container.app.flashCount = 6 // 3 flashes (on/off cycles)
-		cp.container.commands = append(cp.container.commands, tea.Batch(getChats(), flashTick()))
+		cp.container.commands = append(cp.container.commands, tea.Batch(getChats(cp.container.app.current.backend), flashTick()))
 		return cp, nil
+
+	case msgAckMsg:
+		cp.updateLastMessage(msg.ChatID, msg.MsgID, func(m *message) { m.setAck(msg.Ack) })
+	case msgRevokedMsg:
+		cp.updateLastMessage(msg.ChatID, msg.MsgID, func(m *message) { m.revoke() })
+	case msgEditedMsg:
+		cp.updateLastMessage(msg.ChatID, msg.MsgID, func(m *message) { m.edit(msg.Body) })
 	}
 
 	return cp, nil
@@ -166,33 +247,48 @@ func (cp chats_page) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 func (cp chats_page) View() string {
 	var b strings.Builder
-	b.WriteString("Chats:\n\n")
+	if cp.forwarding.isForwarding {
+		fmt.Fprintf(&b, "Forward %d message(s) to (%d marked, space marks, enter sends):\n\n", len(cp.forwarding.MsgIDs), len(cp.forwarding.marked))
+	} else if len(cp.container.app.accounts) > 1 {
+		b.WriteString("Chats (" + cp.container.app.current.name + "):\n\n")
+	} else {
+		b.WriteString("Chats:\n\n")
+	}
 
 	if len(cp.chats) < 1 {
 		b.WriteString("Loading chats...")
 		return b.String()
 	}
-	availableHeight := cp.container.app.height - 3 // 1 for header, 1 for empty line, 1 for padding
-	if availableHeight < 1 {
-		availableHeight = 1
+	cp.calculateChatLines()
+	startIdx, endIdx := cp.window()
+	for _, line := range markLines("chats", cp.lines[startIdx:endIdx], startIdx) {
+		b.WriteString(line + "\n")
 	}
+	return b.String()
+}
 
-	// Calculate which chats to show based on selection and available container.app.height
-	startIndex := 0
-	endIndex := len(cp.chats)
-
+// calculateChatLines renders every chat, lineChat keeps which chat each line
+// belongs to
+func (cp *chats_page) calculateChatLines() {
 	lines := make([]string, 0)
-	for i := startIndex; i < endIndex; i++ {
-		c := cp.chats[i]
-		name := c.Name
-		if name == "" {
-			name = c.ID
-		}
+	lineChat := make([]int, 0)
+	for i, c := range cp.chats {
 		str := cp.renderChat(c, i)
-		lines = append(lines, strings.Split(str, "\n")...)
+		for _, line := range strings.Split(str, "\n") {
+			lines = append(lines, line)
+			lineChat = append(lineChat, i)
+		}
 	}
 	cp.lines = lines
-	var displayLines []string
+	cp.lineChat = lineChat
+}
+
+// window is the range of lines on screen
+func (cp chats_page) window() (int, int) {
+	availableHeight := cp.container.app.height - 3 // 1 for header, 1 for empty line, 1 for padding
+	if availableHeight < 1 {
+		availableHeight = 1
+	}
 	startIdx := cp.scrollOffset
 	endIdx := cp.scrollOffset + availableHeight
 
@@ -206,22 +302,50 @@ func (cp chats_page) View() string {
 	if startIdx < 0 {
 		startIdx = 0
 	}
+	return startIdx, endIdx
+}
 
-	if startIdx < len(cp.lines) {
-		displayLines = cp.lines[startIdx:endIdx]
+// chatAt is the chat under the mouse, -1 when there is none
+func (cp *chats_page) chatAt(msg tea.MouseMsg) int {
+	cp.calculateChatLines()
+	start, end := cp.window()
+	row, _ := rowAt("chats", start, end, msg)
+	if row == -1 {
+		return -1
 	}
-	for _, line := range displayLines {
-		b.WriteString(line + "\n")
+	return cp.lineChat[row]
+}
+
+// selectChat selects chats[idx] without scrolling, it is on screen
+func (cp *chats_page) selectChat(idx int) {
+	cp.scrollOffset, _ = cp.window()
+	cp.selectedChat = idx
+	cp.curr_line = 0
+	for i := 0; i < idx; i++ {
+		cp.curr_line += len(strings.Split(cp.renderChat(cp.chats[i], i), "\n"))
+	}
+}
+
+// updateLastMessage applies an event to the last message of a chat, if the
+// event is about that message
+func (cp *chats_page) updateLastMessage(chatID, msgID string, update func(m *message)) {
+	for i := range cp.chats {
+		if cp.chats[i].ID == chatID && cp.chats[i].LastMessage.MsgID == msgID {
+			update(&cp.chats[i].LastMessage)
+		}
 	}
-	return b.String()
 }
 
 func (cp *chats_page) renderChat(chat Chat, idx int) string {
 	L := cp.container.app.luaState
 	type chat_to_render_info struct {
-		Is_selected bool `json:"is_selected"`
-		Width       int  `json:"width"`
-		Height      int  `json:"height"`
+		Is_selected bool   `json:"is_selected"`
+		Width       int    `json:"width"`
+		Height      int    `json:"height"`
+		State       string `json:"state"`
+		Draft       string `json:"draft"`
+		Forwarding  bool   `json:"forwarding"`
+		Is_marked   bool   `json:"is_marked"`
 	}
 
 	type chat_to_render struct {
@@ -231,7 +355,15 @@ func (cp *chats_page) renderChat(chat Chat, idx int) string {
 
 	str, err := struct_to_lua_table(
 		chat_to_render{
-			chat_to_render_info{Is_selected: idx == cp.selectedChat, Width: cp.container.app.width, Height: cp.container.app.height},
+			chat_to_render_info{
+				Is_selected: idx == cp.selectedChat,
+				Width:       cp.container.app.width,
+				Height:      cp.container.app.height,
+				State:       cp.container.app.current.chat_states[chat.ID],
+				Draft:       cp.container.app.current.drafts[chat.ID],
+				Forwarding:  cp.forwarding.isForwarding,
+				Is_marked:   cp.forwarding.marked[chat.ID],
+			},
 			chat,
 		},
 	)
@@ -269,35 +401,53 @@ func (cp *chats_page) renderChat(chat Chat, idx int) string {
 	if luaHandled {
 		return renderedLine
 	}
+	name := chat.Name + chatStateSuffix(cp.container.app.current.chat_states[chat.ID])
+	if cp.container.app.current.drafts[chat.ID] != "" {
+		name = "draft: " + name
+	}
+	if cp.forwarding.marked[chat.ID] {
+		name = "[x] " + name
+	} else if cp.forwarding.isForwarding {
+		name = "[ ] " + name
+	}
 	if idx == cp.selectedChat {
-		return fmt.Sprintf("> %s\n", styles["selectedStyle"].Render(chat.Name))
+		return fmt.Sprintf("> %s\n", styles["selectedStyle"].Render(name))
 	}
-	return fmt.Sprintf("  %s\n", styles["unselectedStyle"].Render(chat.Name))
+	return fmt.Sprintf("  %s\n", styles["unselectedStyle"].Render(name))
 }
 
-func forwardMsgToChat(chatID string, msgID string) {
-	res, err := http.Post(fmt.Sprintf("%s/client/1/message/%s/forward/%s", baseURL, msgID, chatID), "application/json", nil)
-	if err != nil {
-		panic(fmt.Errorf("failed to forward message: %w", err))
-	}
-	defer res.Body.Close()
-	if res.StatusCode != http.StatusOK {
-		panic(fmt.Errorf("failed to forward message: %s", res.Status))
+// chatStateSuffix is what the default renderers append to a chat typing or recording
+func chatStateSuffix(state string) string {
+	switch state {
+	case "composing":
+		return " (typing...)"
+	case "recording":
+		return " (recording...)"
 	}
+	return ""
 }
 
-func getChats() tea.Cmd {
-	return func() tea.Msg {
-		res, err := http.Get(fmt.Sprintf("%s/client/1/chat", baseURL))
+// getChats loads the chats, showing the cached ones first when the backend
+// keeps a local copy
+func getChats(b Backend) tea.Cmd {
+	fetch := func() tea.Msg {
+		chats, err := b.Chats()
 		if err != nil {
 			return err
 		}
-		defer res.Body.Close()
-		var chats []Chat
-		if err := json.NewDecoder(res.Body).Decode(&chats); err != nil {
-			return err
-		}
+		return chatsLoadedMsg(chats)
+	}
 
+	cache, ok := b.(cacheReader)
+	if !ok {
+		return fetch
+	}
+	cached := func() tea.Msg {
+		chats, err := cache.CachedChats()
+		if err != nil || len(chats) == 0 {
+			return nil
+		}
 		return chatsLoadedMsg(chats)
 	}
+	return tea.Sequence(cached, fetch)
 }
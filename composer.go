@@ -0,0 +1,262 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/cursor"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textarea"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/ansi"
+)
+
+const (
+	composerPrompt  = " Message: "
+	composerMaxRows = 5   // the bottom bar grows up to this many rows, then scrolls
+	maxInputHistory = 100 // sent messages kept for input_up and history_prev
+)
+
+// externalEditMsg carries the text written in $EDITOR back to the composer
+// of chatID, send tells to send it right away
+type externalEditMsg struct {
+	acc    *account
+	chatID string
+	text   string
+	send   bool
+	err    error
+}
+
+// editExternally suspends the TUI and opens draft in the editor through a
+// temp file, the text saved there comes back as an externalEditMsg
+func editExternally(acc *account, draft, chatID string, send bool) tea.Cmd {
+	f, err := os.CreateTemp("", "whats-cli-*.txt")
+	if err != nil {
+		return func() tea.Msg { return externalEditMsg{acc: acc, chatID: chatID, err: err} }
+	}
+	path := f.Name()
+	_, err = f.WriteString(draft)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		return func() tea.Msg { return externalEditMsg{acc: acc, chatID: chatID, err: err} }
+	}
+
+	return tea.ExecProcess(editorCommand(path), func(err error) tea.Msg {
+		defer os.Remove(path)
+		if err != nil {
+			return externalEditMsg{acc: acc, chatID: chatID, err: err}
+		}
+		text, err := os.ReadFile(path)
+		return externalEditMsg{acc: acc, chatID: chatID, text: string(text), send: send, err: err}
+	})
+}
+
+// keepExternalEdit makes what was written in the editor the draft of its
+// chat when that chat is no longer on screen, it is not sent then. False
+// when the chat is open and its composer takes it
+func (a *app) keepExternalEdit(msg externalEditMsg) bool {
+	if msg.err != nil || msg.acc == nil {
+		return false
+	}
+	if msg.acc == a.current {
+		switch p := a.page_conatiner.page.(type) {
+		case messages_page:
+			if p.from_chat.ID == msg.chatID {
+				return false
+			}
+		case split_page:
+			if p.messages != nil && p.messages.from_chat.ID == msg.chatID {
+				return false
+			}
+		}
+	}
+	// editors end files with a newline
+	msg.acc.setDraft(msg.chatID, strings.TrimRight(msg.text, "\n"))
+	msg.acc.saveDrafts()
+	return true
+}
+
+// newComposer is the input of the messages page, a textarea drawn as the
+// bottom bar
+func newComposer() textarea.Model {
+	ta := textarea.New()
+	ta.ShowLineNumbers = false
+	ta.CharLimit = 0
+	ta.MaxHeight = 0
+	ta.SetPromptFunc(ansi.StringWidth(composerPrompt), func(line int) string {
+		if line == 0 {
+			return composerPrompt
+		}
+		return ""
+	})
+
+	// enter sends, see submit_input
+	ta.KeyMap.InsertNewline = key.NewBinding(key.WithKeys("shift+enter", "alt+enter", "ctrl+j"))
+
+	bar := styles["bottombarStyle"]
+	style := textarea.Style{
+		Base:        bar,
+		CursorLine:  bar,
+		EndOfBuffer: bar,
+		Placeholder: bar,
+		Prompt:      bar,
+		Text:        bar,
+	}
+	ta.FocusedStyle = style
+	ta.BlurredStyle = style
+	ta.Cursor.SetMode(cursor.CursorStatic)
+	ta.Focus()
+	return ta
+}
+
+// composerRows is how many rows the text takes wrapped at width
+func composerRows(text string, width int) int {
+	width = max(1, width)
+	rows := 0
+	for _, line := range strings.Split(text, "\n") {
+		rows += ansi.StringWidth(line)/width + 1
+	}
+	return rows
+}
+
+// sizeComposer fits the composer to the terminal, growing with its text
+func (mp *messages_page) sizeComposer() {
+	mp.input.SetWidth(mp.container.app.width)
+	mp.input.SetHeight(min(composerRows(mp.input.Value(), mp.input.Width()), composerMaxRows))
+}
+
+// barHeight is how many rows the bottom bar takes
+func (mp messages_page) barHeight() int {
+	if mp.searching || mp.reactPicker != nil {
+		return 1
+	}
+	width := mp.container.app.width - ansi.StringWidth(composerPrompt)
+	return min(composerRows(mp.input.Value(), width), composerMaxRows)
+}
+
+// editInput passes a key to the composer
+func (mp *messages_page) editInput(msg tea.KeyMsg) {
+	// at full height the textarea only scrolls when the text doesn't fit
+	mp.input.SetWidth(mp.container.app.width)
+	mp.input.SetHeight(composerMaxRows)
+	mp.input, _ = mp.input.Update(msg)
+	if composerRows(mp.input.Value(), mp.input.Width()) <= composerMaxRows {
+		mp.unscrollComposer()
+	}
+	mp.sizeComposer()
+}
+
+// unscrollComposer scrolls the textarea back to its first row, keeping the
+// cursor where it is. The textarea stays scrolled after its text shrinks
+func (mp *messages_page) unscrollComposer() {
+	line, col := mp.input.Line(), mp.input.LineInfo().StartColumn+mp.input.LineInfo().ColumnOffset
+	mp.input.SetValue(mp.input.Value())
+	for mp.input.Line() > line {
+		mp.input.CursorUp()
+	}
+	mp.input.SetCursor(col)
+}
+
+// inputUp moves the cursor a row up, or to the previous sent message when
+// the cursor is on the first row of a non empty input. False when there is
+// nowhere to go
+func (mp *messages_page) inputUp() bool {
+	mp.sizeComposer()
+	if mp.input.Line() > 0 || mp.input.LineInfo().RowOffset > 0 {
+		mp.input.CursorUp()
+		return true
+	}
+	if mp.input.Value() == "" && mp.historyIdx == -1 {
+		return false
+	}
+	return mp.stepHistory(true)
+}
+
+// inputDown moves the cursor a row down, or to the next sent message while
+// going through them
+func (mp *messages_page) inputDown() bool {
+	mp.sizeComposer()
+	info := mp.input.LineInfo()
+	if mp.input.Line() < mp.input.LineCount()-1 || info.RowOffset < info.Height-1 {
+		mp.input.CursorDown()
+		return true
+	}
+	if mp.historyIdx == -1 {
+		return false
+	}
+	return mp.stepHistory(false)
+}
+
+// stepHistory shows the previous sent message, or the next one when older
+// is false. Going past the newest brings back what was being typed
+func (mp *messages_page) stepHistory(older bool) bool {
+	history := mp.container.app.current.input_history
+	idx := mp.historyIdx
+	if idx == -1 {
+		if !older || len(history) == 0 {
+			return false
+		}
+		mp.historyDraft = mp.input.Value()
+		idx = len(history)
+	}
+
+	if older {
+		idx = max(0, idx-1)
+	} else {
+		idx++
+	}
+	if idx >= len(history) {
+		mp.historyIdx = -1
+		mp.input.SetValue(mp.historyDraft)
+		return true
+	}
+	mp.historyIdx = idx
+	mp.input.SetValue(history[idx])
+	return true
+}
+
+// remember adds a sent message to the input history of the account
+func (acc *account) remember(text string) {
+	if n := len(acc.input_history); n > 0 && acc.input_history[n-1] == text {
+		return
+	}
+	acc.input_history = append(acc.input_history, text)
+	if len(acc.input_history) > maxInputHistory {
+		acc.input_history = acc.input_history[1:]
+	}
+}
+
+// setDraft keeps what is typed in a chat until it is sent, the chat list
+// shows it and reopening the chat brings it back
+func (acc *account) setDraft(chatID, text string) {
+	if acc.drafts[chatID] == text {
+		return
+	}
+	if text == "" {
+		delete(acc.drafts, chatID)
+	} else {
+		acc.drafts[chatID] = text
+	}
+	acc.unsaved[chatID] = true
+}
+
+// saveDrafts writes the changed drafts to the drafts file, so they outlive
+// the process
+func (acc *account) saveDrafts() {
+	if acc.draftFile == nil || len(acc.unsaved) == 0 {
+		return
+	}
+	changed := make(map[string]string, len(acc.unsaved))
+	for chatID := range acc.unsaved {
+		changed[chatID] = acc.drafts[chatID]
+	}
+	if err := acc.draftFile.save(acc.clientID, changed); err != nil {
+		log.Printf("Could not save drafts: %v", err)
+		return
+	}
+	acc.unsaved = make(map[string]bool)
+}
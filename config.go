@@ -0,0 +1,231 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// config holds the startup settings of whats-cli. Values come from the
+// defaults below, then lua/config.lua, then WHATSCLI_* env vars and finally
+// command-line flags, each one overriding the previous.
+type config struct {
+	Backend       string // "whatshttp" or "memory"
+	BackendURL    string // base url of the whatshttp server
+	ClientID      string // whatshttp client id(s) used in /client/{id}/..., comma separated
+	WebhookAddr   string // address the webhook listener binds to
+	WebhookURL    string // url whatshttp posts events to, derived from WebhookAddr if empty
+	WebhookSecret string // shared secret webhook bodies must be HMAC signed with, empty disables it
+	WebhookAllow  string // comma separated ips/cidrs allowed to post webhooks, empty allows all
+	LogFile       string // where logs go while the TUI is running
+	Transport     string // how events arrive: "webhook", "poll" or "sse"
+	PollInterval  string // how often chats are fetched with the poll transport
+	StreamURL     string // server-sent events url for the sse transport, {clientId} is replaced
+	Cache         string // "on" keeps a local copy of chats and messages, "off" disables it
+	CachePath     string // file of the local copy, whats-cli.db next to the binary if empty
+	DraftsPath    string // file drafts are kept in, whats-cli-drafts.json next to the binary if empty
+	DownloadsDir  string // where save_media writes, ~/Downloads if empty
+	OpenWith      string // command saved media is opened with, the system opener if empty
+	ImagePreviews string // how images are drawn: "auto", "kitty", "sixel", "blocks" or "off"
+	Accounts      []accountConfig
+}
+
+// accountConfig is one whatshttp client whats-cli manages
+type accountConfig struct {
+	Name       string
+	ClientID   string
+	BackendURL string // defaults to config.BackendURL
+}
+
+func defaultConfig() config {
+	return config{
+		Backend:       "whatshttp",
+		BackendURL:    "http://localhost:3000",
+		ClientID:      "1",
+		WebhookAddr:   ":4000",
+		Transport:     "webhook",
+		PollInterval:  "5s",
+		Cache:         "on",
+		ImagePreviews: "auto",
+	}
+}
+
+// configOption ties a config field to its config.lua key, env var and flag
+type configOption struct {
+	key   string
+	env   string
+	usage string
+	field func(c *config) *string
+}
+
+var configOptions = []configOption{
+	{"backend", "WHATSCLI_BACKEND", "backend to use: whatshttp or memory",
+		func(c *config) *string { return &c.Backend }},
+	{"backend_url", "WHATSCLI_BACKEND_URL", "base url of the whatshttp server",
+		func(c *config) *string { return &c.BackendURL }},
+	{"client_id", "WHATSCLI_CLIENT_ID", "whatshttp client id",
+		func(c *config) *string { return &c.ClientID }},
+	{"webhook_addr", "WHATSCLI_WEBHOOK_ADDR", "address the webhook listener binds to",
+		func(c *config) *string { return &c.WebhookAddr }},
+	{"webhook_url", "WHATSCLI_WEBHOOK_URL", "url whatshttp posts events to (default http://[local ip][webhook-addr]/whatshttp/webhook)",
+		func(c *config) *string { return &c.WebhookURL }},
+	{"webhook_secret", "WHATSCLI_WEBHOOK_SECRET", "shared secret used to verify the " + signatureHeader + " header of webhooks",
+		func(c *config) *string { return &c.WebhookSecret }},
+	{"webhook_allow", "WHATSCLI_WEBHOOK_ALLOW", "comma separated ips/cidrs allowed to post webhooks",
+		func(c *config) *string { return &c.WebhookAllow }},
+	{"transport", "WHATSCLI_TRANSPORT", "how events arrive: webhook, poll or sse",
+		func(c *config) *string { return &c.Transport }},
+	{"poll_interval", "WHATSCLI_POLL_INTERVAL", "how often chats are fetched with the poll transport",
+		func(c *config) *string { return &c.PollInterval }},
+	{"stream_url", "WHATSCLI_STREAM_URL", "server-sent events url for the sse transport (default [backend-url]/client/{clientId}/events)",
+		func(c *config) *string { return &c.StreamURL }},
+	{"cache", "WHATSCLI_CACHE", "keep a local copy of chats and messages: on or off",
+		func(c *config) *string { return &c.Cache }},
+	{"cache_path", "WHATSCLI_CACHE_PATH", "file of the local copy (default whats-cli.db next to the binary)",
+		func(c *config) *string { return &c.CachePath }},
+	{"drafts_path", "WHATSCLI_DRAFTS_PATH", "file unsent drafts are kept in (default whats-cli-drafts.json next to the binary)",
+		func(c *config) *string { return &c.DraftsPath }},
+	{"downloads_dir", "WHATSCLI_DOWNLOADS_DIR", "directory save_media writes to (default ~/Downloads)",
+		func(c *config) *string { return &c.DownloadsDir }},
+	{"open_with", "WHATSCLI_OPEN_WITH", "command saved media is opened with, ex: mpv (default the system opener)",
+		func(c *config) *string { return &c.OpenWith }},
+	{"image_previews", "WHATSCLI_IMAGE_PREVIEWS", "how images are previewed: auto, kitty, sixel, blocks or off",
+		func(c *config) *string { return &c.ImagePreviews }},
+	{"log_file", "WHATSCLI_LOG_FILE", "file logs are written to while the TUI runs (default whats-cli.log next to the binary)",
+		func(c *config) *string { return &c.LogFile }},
+}
+
+func loadConfig(args []string) (config, error) {
+	cfg := defaultConfig()
+
+	fs := flag.NewFlagSet("whats-cli", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to config.lua (defaults to lua/config.lua next to the binary)")
+	flagValues := make(map[string]*string)
+	for _, opt := range configOptions {
+		flagValues[opt.key] = fs.String(flagName(opt.key), "", opt.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	path := *configPath
+	if path == "" {
+		luaPath, err := ensureLuaPath()
+		if err != nil {
+			return cfg, err
+		}
+		path = filepath.Join(luaPath, "config.lua")
+	}
+	if err := cfg.loadLua(path); err != nil {
+		return cfg, err
+	}
+
+	// an explicit client id replaces the accounts table of config.lua
+	clientIDSet := false
+	for _, opt := range configOptions {
+		if v, ok := os.LookupEnv(opt.env); ok && v != "" {
+			*opt.field(&cfg) = v
+			clientIDSet = clientIDSet || opt.key == "client_id"
+		}
+	}
+	fs.Visit(func(f *flag.Flag) {
+		for _, opt := range configOptions {
+			if flagName(opt.key) == f.Name {
+				*opt.field(&cfg) = *flagValues[opt.key]
+				clientIDSet = clientIDSet || opt.key == "client_id"
+			}
+		}
+	})
+
+	if clientIDSet || len(cfg.Accounts) == 0 {
+		cfg.Accounts = nil
+		for _, id := range strings.Split(cfg.ClientID, ",") {
+			id = strings.TrimSpace(id)
+			if id != "" {
+				cfg.Accounts = append(cfg.Accounts, accountConfig{Name: id, ClientID: id})
+			}
+		}
+	}
+	if len(cfg.Accounts) == 0 {
+		return cfg, fmt.Errorf("no client id configured")
+	}
+	for i := range cfg.Accounts {
+		if cfg.Accounts[i].BackendURL == "" {
+			cfg.Accounts[i].BackendURL = cfg.BackendURL
+		}
+	}
+
+	if cfg.Cache != "on" && cfg.Cache != "off" {
+		return cfg, fmt.Errorf("cache must be on or off, not %q", cfg.Cache)
+	}
+	switch cfg.ImagePreviews {
+	case "auto", "kitty", "sixel", "blocks", "off":
+	default:
+		return cfg, fmt.Errorf("image_previews must be auto, kitty, sixel, blocks or off, not %q", cfg.ImagePreviews)
+	}
+	if cfg.Backend != "whatshttp" && cfg.Backend != "memory" {
+		return cfg, fmt.Errorf("unknown backend %q", cfg.Backend)
+	}
+	return cfg, nil
+}
+
+// loadLua reads the global `config` table of a config.lua file, a missing
+// file just keeps the current values
+func (c *config) loadLua(path string) error {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+
+	L := lua.NewState()
+	defer L.Close()
+	if err := L.DoFile(path); err != nil {
+		return fmt.Errorf("error loading %s: %w", path, err)
+	}
+
+	tbl, ok := L.GetGlobal("config").(*lua.LTable)
+	if !ok {
+		return nil
+	}
+	for _, opt := range configOptions {
+		if v := tbl.RawGetString(opt.key); v != lua.LNil {
+			*opt.field(c) = v.String()
+		}
+	}
+
+	if accounts, ok := tbl.RawGetString("accounts").(*lua.LTable); ok {
+		var err error
+		accounts.ForEach(func(_, value lua.LValue) {
+			acc, ok := value.(*lua.LTable)
+			if !ok {
+				err = fmt.Errorf("config.accounts must be a list of tables")
+				return
+			}
+			ac := accountConfig{
+				Name:     lua.LVAsString(acc.RawGetString("name")),
+				ClientID: lua.LVAsString(acc.RawGetString("client_id")),
+			}
+			ac.BackendURL = lua.LVAsString(acc.RawGetString("backend_url"))
+			if ac.ClientID == "" {
+				err = fmt.Errorf("config.accounts entry without client_id")
+				return
+			}
+			if ac.Name == "" {
+				ac.Name = ac.ClientID
+			}
+			c.Accounts = append(c.Accounts, ac)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flagName turns a config.lua key into its flag name, backend_url -> backend-url
+func flagName(key string) string {
+	return strings.ReplaceAll(key, "_", "-")
+}
@@ -1,6 +1,18 @@
 message_keybinds = {
-	["up"] = function() scroll_up() end,
-	["down"] = function() scroll_down() end,
+	["up"] = function()
+		if not input_up() then
+			scroll_up()
+		end
+	end,
+	["down"] = function()
+		if not input_down() then
+			scroll_down()
+		end
+	end,
+	["ctrl+p"] = function() history_prev() end,
+	["ctrl+n"] = function() history_next() end,
+	["ctrl+x"] = function() edit_input_external() end,
+	["ctrl+o"] = function() attach_files() end,
 	["ctrl+c"] = function() quit() end,
 	["esc"] = function() escape() end,
 	["enter"] = function()
@@ -13,10 +25,82 @@ message_keybinds = {
 	["backspace"] = function() backspace_input() end,
 	["r"] = function() toggle_reply() end,
 	["m"] = function() open_media() end,
+	["s"] = function() save_media() end,
+	["o"] = function() save_media(true) end,
 	["f"] = function() forward_selected() end,
+	[" "] = function() mark_selected() end,
+	["V"] = function() visual_mode() end,
+	["y"] = function() copy_selected() end,
+	["E"] = function() export_selected() end,
+	["e"] = function() edit_selected() end,
 	["d"] = function() delete_selected() end,
+	["+"] = function() react_selected() end,
+	["/"] = function() start_search() end,
+	["n"] = function() search_next() end,
+	["N"] = function() search_prev() end,
+	["left press"] = function() click() end,
+	["wheel up"] = function() scroll_up() end,
+	["wheel down"] = function() scroll_down() end,
+}
+
+message_search_keybinds = {
+	["enter"] = function() submit_input() end,
+	["backspace"] = function() backspace_input() end,
+	["esc"] = function() escape() end,
+	["ctrl+c"] = function() quit() end,
 }
 
+-- used while the reaction picker is open, react_selected() without an emoji opens it
+message_react_keybinds = {
+	["left"] = function() react_prev() end,
+	["right"] = function() react_next() end,
+	["h"] = function() react_prev() end,
+	["l"] = function() react_next() end,
+	["1"] = function() react_pick(1) end,
+	["2"] = function() react_pick(2) end,
+	["3"] = function() react_pick(3) end,
+	["4"] = function() react_pick(4) end,
+	["5"] = function() react_pick(5) end,
+	["6"] = function() react_pick(6) end,
+	["enter"] = function() react_pick() end,
+	["esc"] = function() escape() end,
+	["ctrl+c"] = function() quit() end,
+}
+
+-- used while the delete dialog is open, delete_selected() opens it
+message_delete_keybinds = {
+	["up"] = function() delete_prev() end,
+	["down"] = function() delete_next() end,
+	["k"] = function() delete_prev() end,
+	["j"] = function() delete_next() end,
+	["m"] = function() delete_confirm("me") end,
+	["e"] = function() delete_confirm("everyone") end,
+	["enter"] = function() delete_confirm() end,
+	["esc"] = function() escape() end,
+	["ctrl+c"] = function() quit() end,
+}
+
+-- used while the file picker of attach_files() is open
+message_attach_keybinds = {
+	["up"] = function() attach_prev() end,
+	["down"] = function() attach_next() end,
+	["k"] = function() attach_prev() end,
+	["j"] = function() attach_next() end,
+	[" "] = function() attach_mark() end,
+	["enter"] = function() attach_open() end,
+	["right"] = function() attach_open() end,
+	["l"] = function() attach_open() end,
+	["backspace"] = function() attach_up() end,
+	["left"] = function() attach_up() end,
+	["h"] = function() attach_up() end,
+	["tab"] = function() attach_filter() end,
+	["t"] = function() attach_filter() end,
+	["esc"] = function() escape() end,
+	["ctrl+c"] = function() quit() end,
+}
+
+quick_reactions = { "👍", "❤️", "😂", "😮", "😢", "🙏" }
+
 chat_keybinds = {
 	["up"] = function() chat_scroll_up() end,
 	["down"] = function() chat_scroll_down() end,
@@ -25,6 +109,42 @@ chat_keybinds = {
 	["ctrl+c"] = function() chat_escape() end,
 	["esc"] = function() chat_escape() end,
 	["enter"] = function() chat_select() end,
+	[" "] = function() chat_mark() end,
+	["a"] = function() open_accounts() end,
+	["/"] = function() open_search() end,
+	["left press"] = function() chat_click() end,
+	["wheel up"] = function() chat_scroll_up() end,
+	["wheel down"] = function() chat_scroll_down() end,
+}
+
+account_keybinds = {
+	["up"] = function() account_scroll_up() end,
+	["down"] = function() account_scroll_down() end,
+	["k"] = function() account_scroll_up() end,
+	["j"] = function() account_scroll_down() end,
+	["esc"] = function() account_escape() end,
+	["enter"] = function() account_select() end,
+}
+
+search_keybinds = {
+	["up"] = function() search_scroll_up() end,
+	["down"] = function() search_scroll_down() end,
+	["ctrl+k"] = function() search_scroll_up() end,
+	["ctrl+j"] = function() search_scroll_down() end,
+	["backspace"] = function() search_backspace() end,
+	["esc"] = function() search_escape() end,
+	["enter"] = function() search_select() end,
+}
+
+layout = {
+	mode = "split", -- "split" shows the chat list and the open chat side by side
+	chats_width = 0.3, -- columns, or a fraction of the terminal
+}
+
+split_keybinds = {
+	["tab"] = function() focus_next_pane() end,
+	["ctrl+left"] = function() resize_pane(-2) end,
+	["ctrl+right"] = function() resize_pane(2) end,
 }
 
 renders = {
@@ -37,6 +157,10 @@ renders = {
 		local name        = tostring(info["name"] or "")
 		local headerSpace = tonumber(info["header_height"]) or 2
 
+		if info["is_marked"] then
+			name = "[x] " .. name
+		end
+
 		local iso_timestamp   = tostring(msg["timestamp"] or "")
 		local year, month, day, hour, min = iso_timestamp:match("(%d+)%-(%d+)%-(%d+)T(%d+):(%d+)")
 		local timestamp = string.format("%s:%s - %s/%s", hour, min, month, day)
@@ -110,6 +234,12 @@ renders = {
 		for _, line in ipairs(lines) do
 			table.insert(rendered, line)
 		end
+		-- the thumbnail of images sits under the text, like an embed
+		if info["preview"] then
+			for line in info["preview"]:gmatch("[^\n]+") do
+				table.insert(rendered, line)
+			end
+		end
 		table.insert(rendered, string.rep("-", termWidth))
 
 		return table.concat(rendered, "\n")
@@ -117,9 +247,13 @@ renders = {
 
 
 	["chat"] = function(tbl)
+		local name = tbl['chat']['name']
+		if tbl['info']['forwarding'] then
+			name = (tbl['info']['is_marked'] and "[x] " or "[ ] ") .. name
+		end
 		if tbl['info']['is_selected'] then
-			return fg(styles.selectedStyle.fg) .. "> " .. tbl['chat']['name'] .. reset() .. "\n"
+			return fg(styles.selectedStyle.fg) .. "> " .. name .. reset() .. "\n"
 		end
-		return fg(styles.unselectedStyle.fg) .. "  " .. tbl['chat']['name'] .. reset() .. "\n"
+		return fg(styles.unselectedStyle.fg) .. "  " .. name .. reset() .. "\n"
 	end
 }
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// deleteChoice is an option of the delete dialog
+type deleteChoice struct {
+	label    string
+	everyone bool
+	cancel   bool
+}
+
+// deletePrompt is the dialog asking how to delete the selected messages
+type deletePrompt struct {
+	msgIDs  []string
+	choices []deleteChoice
+	idx     int
+}
+
+// messageDeletedMsg reports how deleting a message went, orig is the
+// message as it was before showing it deleted
+type messageDeletedMsg struct {
+	chatID   string
+	orig     message
+	everyone bool
+	err      error
+}
+
+// deleteMessage deletes a message in the background
+func deleteMessage(b Backend, chatID string, orig message, everyone bool) tea.Cmd {
+	return func() tea.Msg {
+		err := b.DeleteMessage(chatID, orig.MsgID, everyone)
+		return messageDeletedMsg{chatID: chatID, orig: orig, everyone: everyone, err: err}
+	}
+}
+
+// promptDelete opens the delete dialog for the selected messages. Only our
+// own messages can be deleted for everyone
+func (mp *messages_page) promptDelete() {
+	prompt := &deletePrompt{}
+	forEveryone := true
+	for _, idx := range mp.selection() {
+		msg := mp.messages[idx]
+		if msg.MsgID == "" {
+			continue
+		}
+		prompt.msgIDs = append(prompt.msgIDs, msg.MsgID)
+		forEveryone = forEveryone && msg.FromMe && msg.Type != "revoked"
+	}
+	if len(prompt.msgIDs) == 0 {
+		return
+	}
+	prompt.choices = append(prompt.choices, deleteChoice{label: "Delete for me"})
+	if forEveryone {
+		prompt.choices = append(prompt.choices, deleteChoice{label: "Delete for everyone", everyone: true})
+	}
+	prompt.choices = append(prompt.choices, deleteChoice{label: "Cancel", cancel: true})
+	mp.deleting = prompt
+}
+
+// confirmDelete runs a choice of the delete dialog, showing the messages as
+// deleted until the backend answers
+func (mp *messages_page) confirmDelete(choice deleteChoice) {
+	msgIDs := mp.deleting.msgIDs
+	mp.deleting = nil
+	if choice.cancel {
+		return
+	}
+	for _, msgID := range msgIDs {
+		orig, idx := mp.findMessageByID(msgID)
+		if idx == -1 {
+			continue
+		}
+		mp.messages[idx].revoke()
+		cmd := deleteMessage(mp.container.app.current.backend, mp.from_chat.ID, orig, choice.everyone)
+		mp.container.commands = append(mp.container.commands, cmd)
+	}
+	mp.clearSelection()
+}
+
+// deleteDone puts the message back when deleting failed, and takes it off
+// the chat when it was deleted only for us
+func (mp *messages_page) deleteDone(msg messageDeletedMsg) {
+	_, idx := mp.findMessageByID(msg.orig.MsgID)
+	if msg.err != nil {
+		if idx != -1 {
+			mp.messages[idx] = msg.orig
+		}
+		mp.container.commands = append(mp.container.commands, flash(updateFlashMsg{msg: "Could not delete: " + msg.err.Error(), count: 6}))
+		return
+	}
+	if !msg.everyone && idx != -1 {
+		mp.removeMessage(idx)
+	}
+	mp.container.commands = append(mp.container.commands, getMessages(mp.container.app.current.backend, mp.from_chat.ID))
+}
+
+// removeMessage takes messages[idx] off the page, keeping the selection on
+// the message after it
+func (mp *messages_page) removeMessage(idx int) {
+	mp.messages = append(mp.messages[:idx:idx], mp.messages[idx+1:]...)
+	if mp.selectedMsg > idx || mp.selectedMsg >= len(mp.messages) {
+		mp.selectedMsg--
+	}
+	if mp.selectedMsg < 0 && !mp.inInput {
+		mp.inInput = true
+	}
+	if mp.replyingToMsg == idx {
+		mp.replyingToMsg = -1
+	} else if mp.replyingToMsg > idx {
+		mp.replyingToMsg--
+	}
+	highlights := make(map[int]bool, len(mp.replyHighlights))
+	for i, on := range mp.replyHighlights {
+		if i < idx {
+			highlights[i] = on
+		} else if i > idx {
+			highlights[i-1] = on
+		}
+	}
+	mp.replyHighlights = highlights
+	mp.findMatches()
+	mp.calculateMessageLines()
+}
+
+// overlayDialog draws the delete dialog centered over the lines of the
+// messages
+func (mp messages_page) overlayDialog(lines []string) []string {
+	var b strings.Builder
+	if n := len(mp.deleting.msgIDs); n > 1 {
+		b.WriteString("Delete " + pluralMessages(n) + "?\n")
+	} else {
+		b.WriteString("Delete this message?\n")
+	}
+	for i, choice := range mp.deleting.choices {
+		b.WriteString("\n")
+		if i == mp.deleting.idx {
+			b.WriteString(styles["selectedStyle"].Render("> " + choice.label))
+		} else {
+			b.WriteString("  " + choice.label)
+		}
+	}
+	return mp.overlayBox(lines, b.String())
+}
+
+// overlayBox draws content in a rounded box centered over lines
+func (mp messages_page) overlayBox(lines []string, content string) []string {
+	dialog := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		Padding(0, 2).
+		Render(content)
+
+	width := mp.container.app.width
+	boxLines := strings.Split(dialog, "\n")
+	top := max(0, (len(lines)-len(boxLines))/2)
+	for i, line := range boxLines {
+		if top+i >= len(lines) {
+			break
+		}
+		pad := max(0, (width-ansi.StringWidth(line))/2)
+		lines[top+i] = strings.Repeat(" ", pad) + line
+	}
+	return lines
+}
+
+// registerDeleteFuncs exposes the delete dialog to lua
+func (mp *messages_page) registerDeleteFuncs() {
+	L := mp.container.app.luaState
+
+	L.SetGlobal("delete_selected", L.NewFunction(func(L *lua.LState) int {
+		if !mp.inInput && mp.selectedMsg >= 0 && mp.selectedMsg < len(mp.messages) {
+			mp.promptDelete()
+		} else {
+			mp.container.app.luaReturn = "type"
+		}
+		return 0
+	}))
+	L.SetGlobal("delete_next", L.NewFunction(func(L *lua.LState) int {
+		if mp.deleting != nil {
+			mp.deleting.idx = (mp.deleting.idx + 1) % len(mp.deleting.choices)
+		}
+		return 0
+	}))
+	L.SetGlobal("delete_prev", L.NewFunction(func(L *lua.LState) int {
+		if mp.deleting != nil {
+			n := len(mp.deleting.choices)
+			mp.deleting.idx = (mp.deleting.idx + n - 1) % n
+		}
+		return 0
+	}))
+	// delete_confirm takes "me", "everyone" or "cancel", the highlighted
+	// choice without one
+	L.SetGlobal("delete_confirm", L.NewFunction(func(L *lua.LState) int {
+		if mp.deleting == nil {
+			return 0
+		}
+		choice := mp.deleting.choices[mp.deleting.idx]
+		switch L.OptString(1, "") {
+		case "me":
+			choice = deleteChoice{}
+		case "everyone":
+			if !mp.deleting.choices[1].everyone {
+				return 0
+			}
+			choice = mp.deleting.choices[1]
+		case "cancel":
+			choice = deleteChoice{cancel: true}
+		}
+		mp.confirmDelete(choice)
+		return 0
+	}))
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// download is media being written to the downloads directory, a chunk at a
+// time so the bottom bar can show how far it got
+type download struct {
+	media    *mediaFile
+	file     *os.File // path + ".part" until it is complete
+	path     string
+	written  int64
+	open     bool   // open the file once it is saved
+	openWith string // command to open it with, config.open_with if empty
+}
+
+// downloadProgressMsg reports a chunk of a download, dl is nil when it could
+// not start
+type downloadProgressMsg struct {
+	dl   *download
+	done bool
+	err  error
+}
+
+// mediaExtensions are picked over mime.ExtensionsByType, which lists the
+// extensions of a type alphabetically (.jfif for jpegs)
+var mediaExtensions = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/webp":      ".webp",
+	"image/gif":       ".gif",
+	"video/mp4":       ".mp4",
+	"audio/ogg":       ".ogg",
+	"audio/mpeg":      ".mp3",
+	"audio/mp4":       ".m4a",
+	"application/pdf": ".pdf",
+	"text/plain":      ".txt",
+}
+
+// mediaFileName names saved media after the name the sender gave it, or the
+// message id with an extension for its type
+func mediaFileName(msgID string, media *mediaFile) string {
+	name := filepath.Base(media.name)
+	if name == "." || name == string(filepath.Separator) {
+		name = ""
+	}
+	if name == "" {
+		name = strings.Trim(unsafeFileChars.ReplaceAllString(msgID, "_"), "_")
+	}
+	if filepath.Ext(name) != "" {
+		return name
+	}
+	mimeType, _, _ := mime.ParseMediaType(media.mimeType)
+	if ext, ok := mediaExtensions[mimeType]; ok {
+		return name + ext
+	}
+	if exts, _ := mime.ExtensionsByType(mimeType); len(exts) > 0 {
+		return name + exts[0]
+	}
+	return name
+}
+
+// freePath is dir/name, or dir/name (1) and so on when it is taken
+func freePath(dir, name string) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	path := filepath.Join(dir, name)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			return path
+		}
+		path = filepath.Join(dir, fmt.Sprintf("%s (%d)%s", base, i, ext))
+	}
+}
+
+// downloadsDir is config.downloads_dir, ~/Downloads when unset
+func downloadsDir(cfg config) string {
+	dir := cfg.DownloadsDir
+	home, err := os.UserHomeDir()
+	if dir == "" {
+		if err != nil {
+			return "."
+		}
+		return filepath.Join(home, "Downloads")
+	}
+	if err == nil && (dir == "~" || strings.HasPrefix(dir, "~/")) {
+		dir = filepath.Join(home, dir[1:])
+	}
+	return dir
+}
+
+// saveMedia starts downloading the media of a message to dir
+func saveMedia(b Backend, msgID, dir string, open bool, openWith string) tea.Cmd {
+	return func() tea.Msg {
+		media, err := b.Media(msgID)
+		if err != nil {
+			return downloadProgressMsg{err: err}
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			media.Close()
+			return downloadProgressMsg{err: err}
+		}
+		dl := &download{media: media, path: freePath(dir, mediaFileName(msgID, media)), open: open, openWith: openWith}
+		dl.file, err = os.Create(dl.path + ".part")
+		if err != nil {
+			media.Close()
+			return downloadProgressMsg{err: err}
+		}
+		return dl.copy()
+	}
+}
+
+// next copies the following chunk of the download in the background
+func (dl *download) next() tea.Cmd {
+	return func() tea.Msg {
+		return dl.copy()
+	}
+}
+
+// copy writes what arrives in the next 200ms
+func (dl *download) copy() tea.Msg {
+	buf := make([]byte, 32*1024)
+	deadline := time.Now().Add(200 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		n, err := dl.media.Read(buf)
+		if n > 0 {
+			if _, werr := dl.file.Write(buf[:n]); werr != nil {
+				return dl.fail(werr)
+			}
+			dl.written += int64(n)
+		}
+		if err == io.EOF {
+			dl.media.Close()
+			err := dl.file.Close()
+			if err == nil {
+				err = os.Rename(dl.file.Name(), dl.path)
+			}
+			if err != nil {
+				// the .part file is never left behind
+				os.Remove(dl.file.Name())
+				return downloadProgressMsg{dl: dl, err: err}
+			}
+			return downloadProgressMsg{dl: dl, done: true}
+		}
+		if err != nil {
+			return dl.fail(err)
+		}
+	}
+	return downloadProgressMsg{dl: dl}
+}
+
+// fail drops what was written of a download
+func (dl *download) fail(err error) tea.Msg {
+	dl.media.Close()
+	dl.file.Close()
+	os.Remove(dl.file.Name())
+	return downloadProgressMsg{dl: dl, err: err}
+}
+
+// progress is like "photo.jpg 45% (1.2 MB of 2.6 MB)"
+func (dl *download) progress() string {
+	name := filepath.Base(dl.path)
+	if dl.media.size <= 0 {
+		return fmt.Sprintf("%s %s", name, formatSize(dl.written))
+	}
+	return fmt.Sprintf("%s %d%% (%s of %s)", name, dl.written*100/dl.media.size, formatSize(dl.written), formatSize(dl.media.size))
+}
+
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// downloadProgress shows how a download is going in the flash bar and keeps
+// it going, opening the file once it is saved when asked to
+func (a *app) downloadProgress(msg downloadProgressMsg) tea.Cmd {
+	var next tea.Cmd
+	switch {
+	case msg.err != nil:
+		a.flashMsg = "Could not save media: " + msg.err.Error()
+	case msg.done:
+		a.flashMsg = "Saved " + msg.dl.path
+		if msg.dl.open {
+			openWith := msg.dl.openWith
+			if openWith == "" {
+				openWith = a.config.OpenWith
+			}
+			if err := openFile(openWith, msg.dl.path); err != nil {
+				a.flashMsg = "Saved " + msg.dl.path + ", could not open it: " + err.Error()
+			}
+		}
+	default:
+		a.flashMsg = "Saving " + msg.dl.progress()
+		next = msg.dl.next()
+	}
+	ticking := a.flashCount > 0
+	a.flashCount = 6
+	if ticking {
+		return next
+	}
+	return tea.Batch(next, flashTick())
+}
+
+// saveSelectedMedia downloads the media of the selected message, false when
+// it has none
+func (mp *messages_page) saveSelectedMedia(open bool, openWith string) bool {
+	if mp.inInput || mp.selectedMsg < 0 || mp.selectedMsg >= len(mp.messages) {
+		return false
+	}
+	msg := mp.messages[mp.selectedMsg]
+	if !msg.HasMedia || msg.Type == "revoked" || msg.Type == "ciphertext" {
+		return false
+	}
+	a := mp.container.app
+	cmd := saveMedia(a.current.backend, msg.MsgID, downloadsDir(a.config), open, openWith)
+	mp.container.commands = append(mp.container.commands, cmd)
+	return true
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// draftFile keeps the drafts of every account in a small JSON file apart
+// from the cache, so they outlive the process with cache = "off" too
+type draftFile struct {
+	path   string
+	mu     sync.Mutex
+	drafts map[string]map[string]string // client id -> chat id -> unsent input
+}
+
+// openDraftFile reads the drafts at path, a missing file has none
+func openDraftFile(path string) (*draftFile, error) {
+	f := &draftFile{path: path, drafts: make(map[string]map[string]string)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &f.drafts); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// load returns the drafts of an account
+func (f *draftFile) load(clientID string) map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	drafts := make(map[string]string, len(f.drafts[clientID]))
+	for chatID, text := range f.drafts[clientID] {
+		drafts[chatID] = text
+	}
+	return drafts
+}
+
+// save writes the changed drafts of an account, empty ones are removed. The
+// file is replaced at once so a crash does not leave it half written
+func (f *draftFile) save(clientID string, changed map[string]string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.drafts[clientID] == nil {
+		f.drafts[clientID] = make(map[string]string)
+	}
+	for chatID, text := range changed {
+		if text == "" {
+			delete(f.drafts[clientID], chatID)
+		} else {
+			f.drafts[clientID][chatID] = text
+		}
+	}
+	if len(f.drafts[clientID]) == 0 {
+		delete(f.drafts, clientID)
+	}
+
+	data, err := json.MarshalIndent(f.drafts, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
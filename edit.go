@@ -0,0 +1,102 @@
+package main
+
+import (
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// editWindow is how long after sending whatsapp lets a message be edited
+const editWindow = 15 * time.Minute
+
+func (msg *message) edit(body string) {
+	msg.Body = body
+	msg.IsEdited = true
+}
+
+// editedMsg reports how editing a message went, orig is the message as it
+// was before showing the new text
+type editedMsg struct {
+	chatID string
+	orig   message
+	err    error
+}
+
+// editMessage sends the new text of a message in the background
+func editMessage(b Backend, chatID string, orig message, text string) tea.Cmd {
+	return func() tea.Msg {
+		err := b.EditMessage(orig.MsgID, text)
+		return editedMsg{chatID: chatID, orig: orig, err: err}
+	}
+}
+
+// startEdit loads the selected message into the input, submitting it then
+// edits the message. What was being typed comes back once the edit is done
+func (mp *messages_page) startEdit() {
+	msg := mp.messages[mp.selectedMsg]
+	var problem string
+	switch {
+	case !msg.FromMe:
+		problem = "Only your messages can be edited"
+	case msg.Type == "revoked" || msg.Body == "":
+		problem = "This message can't be edited"
+	case time.Since(msg.Timestamp) > editWindow:
+		problem = "Messages can only be edited for 15 minutes"
+	}
+	if problem != "" {
+		mp.container.commands = append(mp.container.commands, flash(updateFlashMsg{msg: problem, count: 6}))
+		return
+	}
+
+	if mp.editingMsg == "" {
+		mp.editDraft = mp.input.Value()
+	}
+	mp.editingMsg = msg.MsgID
+	mp.replyHighlights = make(map[int]bool)
+	mp.replyingToMsg = -1
+	mp.inInput = true
+	mp.historyIdx = -1
+	mp.input.SetValue(msg.Body)
+	mp.sizeComposer()
+}
+
+// stopEdit leaves editing, bringing back what was being typed before
+func (mp *messages_page) stopEdit() {
+	mp.editingMsg = ""
+	mp.input.SetValue(mp.editDraft)
+	mp.editDraft = ""
+	mp.sizeComposer()
+}
+
+// submitEdit sends text as the new body of the message being edited
+func (mp *messages_page) submitEdit(text string) {
+	msgID := mp.editingMsg
+	mp.stopEdit()
+	msg, idx := mp.findMessageByID(msgID)
+	if idx == -1 || text == "" || text == msg.Body {
+		return
+	}
+	mp.messages[idx].edit(text)
+	cmd := editMessage(mp.container.app.current.backend, mp.from_chat.ID, msg, text)
+	mp.container.commands = append(mp.container.commands, cmd)
+}
+
+// editDone puts the old text back when the edit didn't go through
+func (mp *messages_page) editDone(msg editedMsg) {
+	if msg.err == nil {
+		return
+	}
+	if _, idx := mp.findMessageByID(msg.orig.MsgID); idx != -1 {
+		mp.messages[idx].Body = msg.orig.Body
+		mp.messages[idx].IsEdited = msg.orig.IsEdited
+	}
+	mp.container.commands = append(mp.container.commands, flash(updateFlashMsg{msg: "Could not edit: " + msg.err.Error(), count: 6}))
+}
+
+// draft is what is left typed in the chat, the edit being made doesn't count
+func (mp messages_page) draft() string {
+	if mp.editingMsg != "" {
+		return mp.editDraft
+	}
+	return mp.input.Value()
+}
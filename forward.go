@@ -0,0 +1,105 @@
+package main
+
+import (
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// forwardTarget is a chat messages are being forwarded to
+type forwardTarget struct {
+	chatID string
+	name   string
+	done   bool
+	err    error
+}
+
+// forwardResultMsg reports how forwarding to one chat went
+type forwardResultMsg struct {
+	chatID string
+	err    error
+}
+
+// forwardTo forwards msgIDs to a chat in order, stopping at the first that
+// fails. Each chat gets its own command so they are forwarded concurrently
+func forwardTo(b Backend, msgIDs []string, chatID string) tea.Cmd {
+	return func() tea.Msg {
+		for _, msgID := range msgIDs {
+			if err := b.ForwardMessage(msgID, chatID); err != nil {
+				return forwardResultMsg{chatID: chatID, err: err}
+			}
+		}
+		return forwardResultMsg{chatID: chatID}
+	}
+}
+
+// forward starts forwarding the messages of the picker to the marked chats,
+// or to the selected one when none is marked
+func (cp *chats_page) forward() {
+	var targets []forwardTarget
+	for _, chat := range cp.chats {
+		if cp.forwarding.marked[chat.ID] {
+			targets = append(targets, forwardTarget{chatID: chat.ID, name: chat.Name})
+		}
+	}
+	if len(targets) == 0 && cp.selectedChat < len(cp.chats) {
+		chat := cp.chats[cp.selectedChat]
+		targets = append(targets, forwardTarget{chatID: chat.ID, name: chat.Name})
+	}
+
+	a := cp.container.app
+	a.forwards = targets
+	for _, t := range targets {
+		cp.container.commands = append(cp.container.commands, forwardTo(a.current.backend, cp.forwarding.MsgIDs, t.chatID))
+	}
+	if a.flashCount == 0 {
+		cp.container.commands = append(cp.container.commands, flashTick())
+	}
+	a.showForwards()
+}
+
+// forwardDone records the result of a forward, the flash bar shows how
+// every chat is going
+func (a *app) forwardDone(msg forwardResultMsg) tea.Cmd {
+	for i := range a.forwards {
+		if a.forwards[i].chatID == msg.chatID && !a.forwards[i].done {
+			a.forwards[i].done = true
+			a.forwards[i].err = msg.err
+			break
+		}
+	}
+	ticking := a.flashCount > 0
+	a.showForwards()
+	if ticking {
+		return nil
+	}
+	return flashTick()
+}
+
+// showForwards puts the state of each forward in the flash bar, like
+// "Forwarding: Alice ok, Bob FAILED (timeout), Carl ..."
+func (a *app) showForwards() {
+	parts := make([]string, len(a.forwards))
+	pending := false
+	for i, t := range a.forwards {
+		name := t.name
+		if name == "" {
+			name = t.chatID
+		}
+		switch {
+		case !t.done:
+			parts[i] = name + " ..."
+			pending = true
+		case t.err != nil:
+			parts[i] = name + " FAILED (" + t.err.Error() + ")"
+		default:
+			parts[i] = name + " ok"
+		}
+	}
+	label := "Forwarded: "
+	if pending {
+		label = "Forwarding: "
+	}
+	a.flashMsg = label + strings.Join(parts, ", ")
+	a.flashCount = 6
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// layout is the lua layout table: mode "single" shows one page at a time,
+// "split" the chat list and the open chat side by side. ChatsWidth is in
+// columns, or a fraction of the terminal when it is at most 1
+type layout struct {
+	Mode       string
+	ChatsWidth float64
+}
+
+const (
+	paneChats = iota
+	paneMessages
+)
+
+// readLayout reads the layout table left by init.lua
+func readLayout(L *lua.LState) layout {
+	l := layout{Mode: "single", ChatsWidth: 0.3}
+	tbl, ok := L.GetGlobal("layout").(*lua.LTable)
+	if !ok {
+		return l
+	}
+	if mode, ok := tbl.RawGetString("mode").(lua.LString); ok {
+		l.Mode = string(mode)
+	}
+	if width, ok := tbl.RawGetString("chats_width").(lua.LNumber); ok && width > 0 {
+		l.ChatsWidth = float64(width)
+	}
+	return l
+}
+
+// arrange puts the chats and messages pages a page turned into side by side
+// when the layout is split
+func (pc *pageContainer) arrange(p tea.Model) tea.Model {
+	if pc.app.layout.Mode != "split" {
+		return p
+	}
+	switch p := p.(type) {
+	case split_page:
+		pc.split = &p
+		return p
+	case chats_page:
+		sp := pc.lastSplit()
+		sp.chats = p
+		sp.focus = paneChats
+		pc.split = &sp
+		return sp
+	case messages_page:
+		sp := pc.lastSplit()
+		if len(sp.chats.chats) == 0 {
+			pc.commands = append(pc.commands, getChats(pc.app.current.backend))
+		}
+		sp.messages = &p
+		sp.focus = paneMessages
+		pc.split = &sp
+		return sp
+	}
+	return p
+}
+
+// lastSplit is the split page to go back to, a new one after switching
+// accounts
+func (pc *pageContainer) lastSplit() split_page {
+	if pc.split != nil && pc.split.account == pc.app.current {
+		return *pc.split
+	}
+	return new_split_page(pc)
+}
+
+type split_page struct {
+	chats     chats_page
+	messages  *messages_page // nil until a chat is opened
+	focus     int
+	account   *account
+	container *pageContainer
+}
+
+func new_split_page(container *pageContainer) split_page {
+	if container == nil {
+		panic("passed nil container")
+	}
+
+	sp := split_page{}
+	sp.container = container
+	sp.chats = new_chats_page(container)
+	sp.account = container.app.current
+	sp.focus = paneChats
+	return sp
+}
+
+func (sp split_page) Init() tea.Cmd {
+	return nil
+}
+
+// paneWidths splits the terminal between the panes and the separator,
+// both are 0 when it is too narrow to split
+func (sp split_page) paneWidths() (int, int) {
+	total := sp.container.app.width
+	if total < 40 {
+		return 0, 0
+	}
+	chats := int(sp.container.app.layout.ChatsWidth)
+	if sp.container.app.layout.ChatsWidth <= 1 {
+		chats = int(float64(total) * sp.container.app.layout.ChatsWidth)
+	}
+	chats = max(10, min(chats, total-21))
+	return chats, total - chats - 1
+}
+
+// withWidth runs f with the app as wide as a pane, pages size everything
+// from app.width
+func (sp split_page) withWidth(width int, f func()) {
+	app := sp.container.app
+	full := app.width
+	if width > 0 {
+		app.width = width
+	}
+	defer func() { app.width = full }()
+	f()
+}
+
+func (sp *split_page) registerLuaFuncs() {
+	L := sp.container.app.luaState
+	L.SetGlobal("focus_next_pane", L.NewFunction(func(L *lua.LState) int {
+		if sp.focus == paneChats && sp.messages != nil {
+			sp.focus = paneMessages
+		} else {
+			sp.focus = paneChats
+		}
+		return 0
+	}))
+	L.SetGlobal("focus_pane", L.NewFunction(func(L *lua.LState) int {
+		switch L.ToString(1) {
+		case "chats":
+			sp.focus = paneChats
+		case "messages":
+			if sp.messages != nil {
+				sp.focus = paneMessages
+			}
+		}
+		return 0
+	}))
+	L.SetGlobal("resize_pane", L.NewFunction(func(L *lua.LState) int {
+		chats, _ := sp.paneWidths()
+		sp.container.app.layout.ChatsWidth = float64(chats + L.ToInt(1))
+		chats, _ = sp.paneWidths()
+		sp.container.app.layout.ChatsWidth = float64(chats)
+		return 0
+	}))
+	L.SetGlobal("set_pane_width", L.NewFunction(func(L *lua.LState) int {
+		if width := float64(L.ToNumber(1)); width > 0 {
+			sp.container.app.layout.ChatsWidth = width
+		}
+		return 0
+	}))
+}
+
+// place puts the page a pane turned into back in the split, pages other than
+// chats and messages take the whole screen. Only keys and clicks move the
+// focus
+func (sp split_page) place(p tea.Model, from int, focus bool) tea.Model {
+	switch p := p.(type) {
+	case chats_page:
+		// leaving a chat keeps the list where it was, picking where to
+		// forward a message replaces it
+		if from == paneChats || p.forwarding.isForwarding {
+			sp.chats = p
+		}
+		if focus {
+			sp.focus = paneChats
+		}
+	case messages_page:
+		sp.messages = &p
+		if focus {
+			sp.focus = paneMessages
+		}
+	default:
+		return p
+	}
+	return sp
+}
+
+func (sp split_page) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	chatsWidth, messagesWidth := sp.paneWidths()
+
+	switch msg.(type) {
+	case tea.KeyMsg, tea.MouseMsg:
+		sp.registerLuaFuncs()
+		L := sp.container.app.luaState
+		err := L.DoString(fmt.Sprintf(`
+			handled = false
+			local key = %q
+			local f = (split_keybinds or {})[key]
+			if type(f) == "function" then
+				f()
+				handled = true
+			end
+		`, msg.(fmt.Stringer).String()))
+		if err != nil {
+			fmt.Println("Lua error:", err)
+		}
+		handled := L.GetGlobal("handled") == lua.LTrue
+		L.SetGlobal("handled", lua.LBool(false))
+		if handled {
+			return sp, nil
+		}
+
+		// keys go to the focused pane, the mouse to the pane under it and
+		// clicking a pane focuses it
+		pane, focus := sp.focus, true
+		if mouse, ok := msg.(tea.MouseMsg); ok {
+			focus = mouse.Action == tea.MouseActionPress && !tea.MouseEvent(mouse).IsWheel()
+			if chatsWidth > 0 && mouse.X < chatsWidth {
+				pane = paneChats
+			} else if chatsWidth > 0 {
+				pane = paneMessages
+			}
+		}
+
+		var p tea.Model
+		if pane == paneMessages && sp.messages != nil {
+			sp.withWidth(messagesWidth, func() { p, _ = sp.messages.Update(msg) })
+			return sp.place(p, paneMessages, focus), nil
+		}
+		if pane == paneMessages {
+			return sp, nil
+		}
+		sp.withWidth(chatsWidth, func() { p, _ = sp.chats.Update(msg) })
+		return sp.place(p, paneChats, focus), nil
+	}
+
+	var p tea.Model
+	sp.withWidth(chatsWidth, func() { p, _ = sp.chats.Update(msg) })
+	next := sp.place(p, paneChats, false)
+	if sp.messages == nil {
+		return next, nil
+	}
+	sp, ok := next.(split_page)
+	if !ok {
+		return next, nil
+	}
+	sp.withWidth(messagesWidth, func() { p, _ = sp.messages.Update(msg) })
+	return sp.place(p, paneMessages, false), nil
+}
+
+// fitPane cuts or pads every line of a pane to width and height
+func fitPane(view string, width, height int) []string {
+	lines := strings.Split(strings.TrimSuffix(view, "\n"), "\n")
+	if len(lines) > height {
+		lines = lines[:height]
+	}
+	for len(lines) < height {
+		lines = append(lines, "")
+	}
+	cut := lipgloss.NewStyle().MaxWidth(width)
+	for i, line := range lines {
+		line = cut.Render(line)
+		lines[i] = line + strings.Repeat(" ", max(0, width-lipgloss.Width(line)))
+	}
+	return lines
+}
+
+func (sp split_page) View() string {
+	chatsWidth, messagesWidth := sp.paneWidths()
+	if chatsWidth == 0 {
+		// too narrow, show the focused pane alone
+		if sp.focus == paneMessages && sp.messages != nil {
+			return sp.messages.View()
+		}
+		return sp.chats.View()
+	}
+
+	height := max(1, sp.container.app.height)
+	var left, right string
+	sp.withWidth(chatsWidth, func() { left = sp.chats.View() })
+	if sp.messages != nil {
+		sp.withWidth(messagesWidth, func() { right = sp.messages.View() })
+	} else {
+		right = "\n  " + styles["unselectedStyle"].Render("Select a chat")
+	}
+	leftLines := fitPane(left, chatsWidth, height)
+	rightLines := fitPane(right, messagesWidth, height)
+
+	// the arrow on top of the separator points at the focused pane
+	var b strings.Builder
+	for i := range height {
+		sep := "│"
+		if i == 0 && sp.focus == paneChats {
+			sep = "◂"
+		} else if i == 0 {
+			sep = "▸"
+		}
+		b.WriteString(leftLines[i] + styles["selectedStyle"].Render(sep) + rightLines[i])
+		if i < height-1 {
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// login_page starts the WhatsApp session of the accounts that are not ready,
+// shows their QR code and waits for it to be scanned before opening the chats
+type login_page struct {
+	container *pageContainer
+	pending   []*account // accounts still to log in, pending[0] is on screen
+	started   bool
+	qr        string // last qr data received, only re-rendered when it changes
+	qrLines   []string
+	status    string
+	attempts  int // failed tries to start the session of pending[0]
+}
+
+// loginStatusMsg reports the session of an account, started is false when
+// it could not be started and has to be tried again
+type loginStatusMsg struct {
+	acc     *account
+	client  client
+	err     error
+	started bool
+}
+
+// loginRetryMax is the longest wait between tries to start a session
+const loginRetryMax = 30 * time.Second
+
+func new_login_page(container *pageContainer, pending []*account) login_page {
+	if container == nil {
+		panic("passed nil container")
+	}
+	return login_page{container: container, pending: pending}
+}
+
+func (lp login_page) Init() tea.Cmd {
+	return nil
+}
+
+// startLogin starts the session of an account after waiting delay, the
+// webhook is only registered when events arrive through it
+func startLogin(acc *account, cfg config, delay time.Duration) tea.Cmd {
+	webHook := ""
+	if cfg.Transport == "webhook" {
+		webHook = webhookURL(cfg, acc)
+	}
+	login := func() tea.Msg {
+		if err := acc.backend.Login(webHook); err != nil {
+			return loginStatusMsg{acc: acc, err: err}
+		}
+		c, err := acc.backend.Client()
+		return loginStatusMsg{acc: acc, client: c, err: err, started: true}
+	}
+	if delay == 0 {
+		return login
+	}
+	return tea.Tick(delay, func(time.Time) tea.Msg { return login() })
+}
+
+func pollLogin(acc *account) tea.Cmd {
+	return tea.Tick(2*time.Second, func(t time.Time) tea.Msg {
+		c, err := acc.backend.Client()
+		return loginStatusMsg{acc: acc, client: c, err: err, started: true}
+	})
+}
+
+func (lp login_page) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if !lp.started {
+		lp.started = true
+		acc := lp.pending[0]
+		lp.status = "Starting session..."
+		lp.attempts = 0
+		lp.container.commands = append(lp.container.commands, startLogin(acc, lp.container.app.config, 0))
+	}
+
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "ctrl+c", "esc":
+			lp.container.commands = append(lp.container.commands, tea.Quit)
+		}
+
+	case loginStatusMsg:
+		if msg.acc != lp.pending[0] {
+			return lp, nil
+		}
+		if msg.err != nil && !msg.started {
+			// 2s, 4s, 8s... up to loginRetryMax
+			lp.attempts++
+			delay := min(loginRetryMax, time.Second<<min(lp.attempts, 5))
+			lp.status = fmt.Sprintf("Error: %v (retrying in %s)", msg.err, delay)
+			lp.container.commands = append(lp.container.commands, startLogin(msg.acc, lp.container.app.config, delay))
+			return lp, nil
+		}
+		if msg.err != nil {
+			lp.status = "Error: " + msg.err.Error()
+			lp.container.commands = append(lp.container.commands, pollLogin(msg.acc))
+			return lp, nil
+		}
+
+		if msg.client.Ready {
+			lp.pending = lp.pending[1:]
+			if len(lp.pending) == 0 {
+				cp := new_chats_page(lp.container)
+				lp.container.commands = append(lp.container.commands, getChats(lp.container.app.current.backend))
+				return lp.container.reset(cp), nil
+			}
+			// the next account starts right away, not on the next key
+			lp.qr = ""
+			lp.qrLines = nil
+			lp.status = "Starting session..."
+			lp.attempts = 0
+			lp.container.commands = append(lp.container.commands, startLogin(lp.pending[0], lp.container.app.config, 0))
+			return lp, nil
+		}
+
+		if msg.client.Qr != "" && msg.client.Qr != lp.qr {
+			lp.qr = msg.client.Qr
+			lines, err := qrToHalfBlocks(msg.client.Qr)
+			if err != nil {
+				lp.status = "Error: " + err.Error()
+			} else {
+				lp.qrLines = lines
+				lp.status = "Scan the code with WhatsApp > Linked devices"
+			}
+		}
+		lp.container.commands = append(lp.container.commands, pollLogin(msg.acc))
+	}
+	return lp, nil
+}
+
+func (lp login_page) View() string {
+	var b strings.Builder
+	b.WriteString("Login (" + lp.pending[0].name + "):\n\n")
+
+	if len(lp.qrLines) == 0 {
+		b.WriteString("Waiting for QR code...\n")
+	}
+	for _, line := range lp.qrLines {
+		b.WriteString(line + "\n")
+	}
+
+	b.WriteString("\n" + lp.status + "\n")
+	b.WriteString(styles["unselectedStyle"].Render("Esc to quit") + "\n")
+	return b.String()
+}
@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestLoginStartsNextAccount(t *testing.T) {
+	first := &account{name: "first", backend: newMemoryBackend()}
+	second := &account{name: "second", backend: newMemoryBackend()}
+	a := &app{accounts: []*account{first, second}, current: first, config: config{Transport: "poll"}}
+	pc := new_page_container(nil, a)
+	lp := new_login_page(pc, []*account{first, second})
+	lp.started = true
+
+	page, _ := lp.Update(loginStatusMsg{acc: first, client: client{Ready: true}, started: true})
+	if got := page.(login_page).pending[0]; got != second {
+		t.Fatalf("on screen after the first is ready: %s, want second", got.name)
+	}
+	if len(pc.commands) != 1 {
+		t.Fatalf("%d commands queued, want the login of second", len(pc.commands))
+	}
+	msg, ok := pc.commands[0]().(loginStatusMsg)
+	if !ok || msg.acc != second {
+		t.Errorf("queued command returned %#v, want the login status of second", msg)
+	}
+}
@@ -1,14 +1,16 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
+	"log"
 	"os"
+	"path/filepath"
 
 	tea "github.com/charmbracelet/bubbletea"
 )
 
-const baseURL = "http://localhost:3000"
-
 
 func max(a, b int) int {
 	if a > b {
@@ -17,19 +19,124 @@ func max(a, b int) int {
 	return b
 }
 
+// newBackend picks the Backend an account runs against from the config
+func newBackend(cfg config, ac accountConfig) Backend {
+	if cfg.Backend == "memory" {
+		return newMemoryBackend()
+	}
+	return newWhatshttpBackend(ac.BackendURL, ac.ClientID)
+}
+
+// openCache opens the local copy of chats and messages, nil when it is
+// disabled or can't be opened
+func openCache(cfg config) *store {
+	if cfg.Cache != "on" || cfg.Backend == "memory" {
+		return nil
+	}
+	path := cfg.CachePath
+	if path == "" {
+		exePath, err := os.Executable()
+		if err != nil {
+			return nil
+		}
+		path = filepath.Join(filepath.Dir(exePath), "whats-cli.db")
+	}
+	st, err := openStore(path)
+	if err != nil {
+		fmt.Printf("Warning: running without cache, could not open %s: %v\n", path, err)
+		return nil
+	}
+	return st
+}
+
+// openDrafts opens the file drafts are kept in, nil keeps them in memory
+func openDrafts(cfg config) *draftFile {
+	if cfg.Backend == "memory" {
+		return nil
+	}
+	path := cfg.DraftsPath
+	if path == "" {
+		exePath, err := os.Executable()
+		if err != nil {
+			return nil
+		}
+		path = filepath.Join(filepath.Dir(exePath), "whats-cli-drafts.json")
+	}
+	drafts, err := openDraftFile(path)
+	if err != nil {
+		fmt.Printf("Warning: drafts will not be kept, could not read %s: %v\n", path, err)
+		return nil
+	}
+	return drafts
+}
+
+func hasCachedChats(b Backend) bool {
+	cache, ok := b.(cacheReader)
+	if !ok {
+		return false
+	}
+	chats, _ := cache.CachedChats()
+	return len(chats) > 0
+}
+
 func main() {
-	err := validateBackend();
+	cfg, err := loadConfig(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
 	if err != nil {
 		fmt.Println("Error:", err)
 		os.Exit(1)
 	}
 
+	st := openCache(cfg)
+	if st != nil {
+		defer st.Close()
+	}
+
+	drafts := openDrafts(cfg)
+
+	accounts := make([]*account, 0, len(cfg.Accounts))
+	var notReady []*account
+	for _, ac := range cfg.Accounts {
+		acc := new_account(cfg, ac, st, drafts)
+		c, err := validateBackend(acc.backend)
+		if err != nil && !hasCachedChats(acc.backend) {
+			fmt.Printf("Error (account %s): %v\n", acc.name, err)
+			os.Exit(1)
+		}
+		if err != nil {
+			// read what we have until the backend comes back
+			log.Printf("Account %s is offline, using cached data: %v", acc.name, err)
+		} else if !c.Ready {
+			notReady = append(notReady, acc)
+		} else {
+			registerWebhook(cfg, acc, c)
+		}
+		accounts = append(accounts, acc)
+	}
+
 	cmdChan := make(chan tea.Msg, 10)
-	startWebhookListener(cmdChan)
+	if err := startTransport(cfg, accounts, cmdChan); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
 
+	// anything logged from now on would be drawn over the TUI
+	logFile := cfg.LogFile
+	if logFile == "" {
+		if exePath, err := os.Executable(); err == nil {
+			logFile = filepath.Join(filepath.Dir(exePath), "whats-cli.log")
+		}
+	}
+	if f, err := tea.LogToFile(logFile, ""); err == nil {
+		defer f.Close()
+	} else {
+		log.SetOutput(io.Discard)
+	}
 
-	a := initialApp()
-	p := tea.NewProgram(*a, tea.WithAltScreen())
+	a := initialApp(cfg, accounts, notReady)
+	p := tea.NewProgram(*a, tea.WithAltScreen(), tea.WithMouseCellMotion(), tea.WithOutput(terminal))
 
 	go func() {
 
@@ -38,7 +145,11 @@ func main() {
 		}
 	}()
 
-	if _, err := p.Run(); err != nil {
+	_, err = p.Run()
+	for _, acc := range accounts {
+		acc.saveDrafts()
+	}
+	if err != nil {
 		fmt.Println("Error:", err)
 		os.Exit(1)
 	}
@@ -1,22 +1,18 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"mime/multipart"
-	"net/http"
-	"net/textproto"
 	"os"
-	"path/filepath"
 	"strings"
 	"time"
 	"unicode/utf8"
 
 	lua "github.com/yuin/gopher-lua"
 
+	"github.com/charmbracelet/bubbles/textarea"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/ansi"
 )
 
 type message struct {
@@ -32,11 +28,29 @@ type message struct {
 	IsResponse   bool            `json:"isQuote"`
 	ResponseToID string          `json:"quoteId"`
 	IsForwarded  bool            `json:"isForwarded"`
+	IsEdited     bool            `json:"isEdited"`
 	MentionedIDs []string        `json:"mentionedIds"`
 	Info         map[string]bool `json:"info"`
+	Reactions    []reaction      `json:"reactions"`
 }
 
-type messagesLoadedMsg []message
+// messagesLoadedMsg is the latest page of a chat
+type messagesLoadedMsg struct {
+	chatID string
+	msgs   []message
+}
+
+// olderMessagesMsg is a page of history older than the message before
+type olderMessagesMsg struct {
+	chatID string
+	before string
+	msgs   []message
+	err    error
+}
+
+// messagesPageSize is how many messages are fetched at a time
+const messagesPageSize = 50
+
 type flashTickMsg struct{}
 type updateFlashMsg struct {
 	count int
@@ -45,7 +59,9 @@ type updateFlashMsg struct {
 type messages_page struct {
 	messages        []message
 	selectedMsg     int
-	input           string
+	input           textarea.Model
+	historyIdx      int    // sent message shown in the input, -1 when none
+	historyDraft    string // what was being typed before going through them
 	inInput         bool
 	replyHighlights map[int]bool
 	replyingToMsg   int
@@ -53,7 +69,23 @@ type messages_page struct {
 	from_chat       *Chat
 	container       *pageContainer
 	lines           []string
+	lineMsg         []int // the message each of lines belongs to
 	curr_line       int
+	jumpToMsg       string // message to select once the messages load
+	searching       bool   // typing a search in the bottom bar
+	searchQuery     string
+	searchHits      []int // indexes of the messages matching searchQuery
+	loadingOlder    bool
+	noOlder         bool // the backend has no history before messages[0]
+	reactPicker     []string // emojis offered while picking a reaction, nil when closed
+	reactIdx        int
+	editingMsg      string // id of the message the input edits, "" when sending
+	editDraft       string // what was being typed before editing
+	deleting        *deletePrompt // the delete dialog, nil when closed
+	marked          map[string]bool // ids of the messages marked for a batch action
+	visualFrom      int             // where visual_mode started, -1 when off
+	attaching       *filePicker     // the file picker, nil when closed
+	attachments     []string        // files sent with the next message
 }
 
 func new_messages_page(chat Chat, container *pageContainer) messages_page {
@@ -67,8 +99,14 @@ func new_messages_page(chat Chat, container *pageContainer) messages_page {
 	mp.replyHighlights = make(map[int]bool)
 	mp.replyingToMsg = -1
 	mp.selectedMsg = -1
+	mp.marked = make(map[string]bool)
+	mp.visualFrom = -1
 	mp.container = container
 	mp.from_chat = &chat
+	mp.input = newComposer()
+	mp.input.SetValue(container.app.current.drafts[chat.ID])
+	mp.sizeComposer()
+	mp.historyIdx = -1
 	return mp
 }
 
@@ -97,19 +135,39 @@ func (mp messages_page) View() string {
 				}
 				topbarText = fmt.Sprintf(" Selected: %s (%s, Esc to return to input)", msg.MsgID, actionText)
 			}
+			if mp.visualFrom != -1 {
+				topbarText = fmt.Sprintf(" Visual: %s selected (V to keep marked, Esc to drop)", pluralMessages(mp.markedCount()))
+			} else if n := mp.markedCount(); n > 0 {
+				topbarText = fmt.Sprintf(" Marked: %s (f forward, d delete, y copy, E export, Esc to clear) |", pluralMessages(n)) + topbarText
+			}
+			for i, hit := range mp.searchHits {
+				if hit == mp.selectedMsg {
+					topbarText = fmt.Sprintf(" Match %d/%d, n/N for older/newer |", len(mp.searchHits)-i, len(mp.searchHits)) + topbarText
+				}
+			}
 		} else {
 			topbarText = " Selected: (Esc to return to input)"
 		}
 	} else {
 		// Check if we're in reply mode
-		if mp.replyingToMsg != -1 && mp.replyingToMsg < len(mp.messages) {
+		if msg, idx := mp.findMessageByID(mp.editingMsg); idx != -1 {
+			topbarText = fmt.Sprintf(" Editing \"%s\" (Enter to save, Esc to cancel)", msg.Body)
+		} else if len(mp.attachments) > 0 {
+			topbarText = mp.attachmentsBar()
+		} else if mp.replyingToMsg != -1 && mp.replyingToMsg < len(mp.messages) {
 			msg := mp.messages[mp.replyingToMsg]
 			topbarText = fmt.Sprintf(" Replying to \"%s\" (ID: %s, Esc to cancel reply)", msg.Body, msg.MsgID)
 		} else {
-			topbarText = " Messages "
+			topbarText = " Messages" + chatStateSuffix(mp.container.app.current.chat_states[mp.from_chat.ID]) + " "
 		}
 	}
 
+	if mp.loadingOlder {
+		topbarText = " Loading older messages... |" + topbarText
+	} else if mp.noOlder && !mp.inInput && mp.selectedMsg == 0 {
+		topbarText = " Start of the chat |" + topbarText
+	}
+
 	// shorten topbar text if it exceeds terminal container.app.width
 	topbarTextLength := utf8.RuneCountInString(topbarText)
 	if topbarTextLength > mp.container.app.width {
@@ -124,51 +182,22 @@ func (mp messages_page) View() string {
 	topbar := styles["topbarStyle"].Width(mp.container.app.width).Render(topbarText + topbarPadding)
 	b.WriteString(topbar + "\n")
 
-	// Calculate available container.app.height for messages (total height - topbar - bottombar)
-	availableHeight := mp.container.app.height - 2 // 1 for topbar, 1 for bottombar
-	if availableHeight < 1 {
-		availableHeight = 1
-	}
-
 	// Get all message lines
 	mp.calculateMessageLines()
-	messageLines := mp.lines
-
-	// Apply scrolling logic
-	var displayLines []string
-	if mp.inInput && mp.replyingToMsg == -1 {
-		// In input mode and NOT replying, show the most recent messages
-		if len(messageLines) > availableHeight {
-			startIdx := len(messageLines) - availableHeight
-			displayLines = messageLines[startIdx:]
-		} else {
-			displayLines = messageLines
-		}
-	} else {
-		// In select mode OR in reply mode, use scroll offset
-		startIdx := mp.scrollOffset
-		endIdx := mp.scrollOffset + availableHeight
-
-		// Ensure we don't scroll past the end
-		if endIdx > len(messageLines) {
-			endIdx = len(messageLines)
-			startIdx = max(0, endIdx-availableHeight)
-		}
-
-		// Ensure we don't scroll before the beginning
-		if startIdx < 0 {
-			startIdx = 0
-		}
-
-		if startIdx < len(messageLines) {
-			displayLines = messageLines[startIdx:endIdx]
-		}
-	}
+	startIdx, endIdx := mp.window()
+	displayLines := markLines("messages", mp.lines[startIdx:endIdx], startIdx)
 
 	// Fill remaining space if needed
-	for len(displayLines) < availableHeight {
+	for len(displayLines) < mp.messagesHeight() {
 		displayLines = append([]string{""}, displayLines...)
 	}
+	displayLines = mp.drawSixels(displayLines, mp.deleting != nil || mp.attaching != nil)
+	if mp.deleting != nil {
+		displayLines = mp.overlayDialog(displayLines)
+	}
+	if mp.attaching != nil {
+		displayLines = mp.overlayPicker(displayLines)
+	}
 
 	for _, line := range displayLines {
 		b.WriteString(line + "\n")
@@ -190,14 +219,34 @@ func (mp messages_page) View() string {
 		flashbar += "\n"
 	}
 	b.WriteString(flashbar)
-	var bottombar string
-	inputText := " Message: " + mp.input
-	bottombarPadding := strings.Repeat(" ", max(0, mp.container.app.width-utf8.RuneCountInString(inputText)))
-	bottombar = styles["bottombarStyle"].Width(mp.container.app.width).Render(inputText + bottombarPadding)
-	b.WriteString(bottombar)
+	if mp.reactPicker != nil {
+		inputText := mp.pickerBar()
+		bottombarPadding := strings.Repeat(" ", max(0, mp.container.app.width-ansi.StringWidth(inputText)))
+		b.WriteString(styles["bottombarStyle"].Width(mp.container.app.width).Render(inputText + bottombarPadding))
+		return b.String()
+	}
+	if mp.searching {
+		inputText := fmt.Sprintf(" Search: %s (%d matches)", mp.searchQuery, len(mp.searchHits))
+		bottombarPadding := strings.Repeat(" ", max(0, mp.container.app.width-utf8.RuneCountInString(inputText)))
+		b.WriteString(styles["bottombarStyle"].Width(mp.container.app.width).Render(inputText + bottombarPadding))
+		return b.String()
+	}
+
+	// the cursor only shows while typing
+	mp.sizeComposer()
+	if !mp.inInput {
+		mp.input.Blur()
+	}
+	b.WriteString(mp.input.View())
 	return b.String()
 }
 
+// messagesHeight is how many rows are left for the messages between the top
+// and bottom bars
+func (mp messages_page) messagesHeight() int {
+	return max(1, mp.container.app.height-1-mp.barHeight())
+}
+
 func (mp messages_page) findMessageByID(msgID string) (message, int) {
 	for i, msg := range mp.messages {
 		if msg.MsgID == msgID {
@@ -207,202 +256,501 @@ func (mp messages_page) findMessageByID(msgID string) (message, int) {
 	return message{}, -1
 }
 
+func (mp messages_page) senderName(msg message) string {
+	var sender_id string
+	if strings.Contains(mp.from_chat.ID, "@g.us") {
+		sender_id = msg.GroupFrom
+	} else {
+		sender_id = msg.From
+	}
+
+	sender, ok := mp.container.app.current.id_to_name[sender_id]
+	if !ok {
+		sender = sender_id
+	}
+	if msg.FromMe {
+		sender = "You"
+	}
+	return sender
+}
+
 func (mp *messages_page) calculateMessageLines() {
 	var messageLines []string
+	var lineMsg []int
 
 	for i, msg := range mp.messages {
-
-		var sender_id string
-		if strings.Contains(mp.from_chat.ID, "@g.us") {
-			sender_id = msg.GroupFrom
-		} else {
-			sender_id = msg.From
+		renderedLine, _ := mp.renderMsg(msg, i, mp.senderName(msg))
+		lines := strings.Split(renderedLine, "\n")
+		messageLines = append(messageLines, lines...)
+		for range lines {
+			lineMsg = append(lineMsg, i)
 		}
+	}
+
+	mp.lines = messageLines
+	mp.lineMsg = lineMsg
+}
+
+// window is the range of lines on screen
+func (mp messages_page) window() (int, int) {
+	availableHeight := mp.messagesHeight()
+
+	if mp.inInput && mp.replyingToMsg == -1 {
+		// In input mode and NOT replying, show the most recent messages
+		return max(0, len(mp.lines)-availableHeight), len(mp.lines)
+	}
+
+	// In select mode OR in reply mode, use scroll offset
+	startIdx := mp.scrollOffset
+	endIdx := mp.scrollOffset + availableHeight
+
+	// Ensure we don't scroll past the end
+	if endIdx > len(mp.lines) {
+		endIdx = len(mp.lines)
+		startIdx = max(0, endIdx-availableHeight)
+	}
+
+	// Ensure we don't scroll before the beginning
+	if startIdx < 0 {
+		startIdx = 0
+	}
+	return startIdx, endIdx
+}
 
-		sender, ok := mp.container.app.id_to_name[sender_id]
-		if !ok {
-			sender = sender_id
+// getMessages loads the messages of a chat, showing the cached ones first
+// when the backend keeps a local copy
+func getMessages(b Backend, chatId string) tea.Cmd {
+	fetch := func() tea.Msg {
+		msgs, err := b.Messages(chatId, "", messagesPageSize)
+		if err != nil {
+			return updateFlashMsg{msg: "Could not load messages: " + err.Error(), count: 6}
 		}
-		if msg.FromMe {
-			sender = "You"
+		return messagesLoadedMsg{chatID: chatId, msgs: msgs}
+	}
+
+	cache, ok := b.(cacheReader)
+	if !ok {
+		return fetch
+	}
+	cached := func() tea.Msg {
+		msgs, err := cache.CachedMessages(chatId)
+		if err != nil || len(msgs) == 0 {
+			return nil
 		}
+		return messagesLoadedMsg{chatID: chatId, msgs: pageBefore(msgs, "", messagesPageSize)}
+	}
+	return tea.Sequence(cached, fetch)
+}
 
-		renderedLine, _ := mp.renderMsg(msg, i, sender)
-		lines := strings.Split(renderedLine, "\n")
-		messageLines = append(messageLines, lines...)
+// getOlderMessages loads the page of history before the message before
+func getOlderMessages(b Backend, chatId, before string) tea.Cmd {
+	return func() tea.Msg {
+		msgs, err := b.Messages(chatId, before, messagesPageSize)
+		return olderMessagesMsg{chatID: chatId, before: before, msgs: msgs, err: err}
 	}
+}
 
-	mp.lines = messageLines
+// keepOlderMessages puts the messages of loaded sent before latest in front
+// of it, so reloading the latest page keeps the history paged in
+func keepOlderMessages(loaded, latest []message) []message {
+	if len(loaded) == 0 || len(latest) == 0 {
+		return latest
+	}
+	ids := make(map[string]bool, len(latest))
+	for _, m := range latest {
+		ids[m.MsgID] = true
+	}
+	var older []message
+	for _, m := range loaded {
+		if m.MsgID != "" && !ids[m.MsgID] && m.Timestamp.Before(latest[0].Timestamp) {
+			older = append(older, m)
+		}
+	}
+	return append(older, latest...)
 }
 
-func getMessages(chatId string) tea.Cmd {
+func sendMessage(b Backend, chatId, text string) tea.Cmd {
+	return sendReply(b, chatId, text, "")
+}
+
+func sendReply(b Backend, chatId, text, responseToId string) tea.Cmd {
 	return func() tea.Msg {
-		res, err := http.Get(fmt.Sprintf("%s/client/1/chat/%s/messages", baseURL, chatId))
-		if err != nil {
+		if err := b.SendMessage(chatId, text, responseToId); err != nil {
 			return err
 		}
-		defer res.Body.Close()
-		var msgs []message
-		if err := json.NewDecoder(res.Body).Decode(&msgs); err != nil {
-			msgs = append(msgs, message{Body: err.Error()})
-		}
-		return messagesLoadedMsg(msgs)
+		return getMessages(b, chatId)()
 	}
 }
 
-func sendMessage(chatId, text string) tea.Cmd {
+func sendMedia(b Backend, chatId, mediaPath, caption, responseToId string) tea.Cmd {
 	return func() tea.Msg {
-		data := map[string]string{"message": text}
-		body, _ := json.Marshal(data)
-		res, err := http.Post(
-			fmt.Sprintf("%s/client/1/chat/%s/send", baseURL, chatId),
-			"application/json",
-			bytes.NewReader(body),
-		)
+		err := b.SendMedia(chatId, mediaPath, caption, responseToId)
+		if errors.Is(err, os.ErrNotExist) {
+			return updateFlashMsg{msg: "File not found!", count: 6}
+		}
 		if err != nil {
 			return err
 		}
-		io.Copy(io.Discard, res.Body)
-		res.Body.Close()
-		return getMessages(chatId)()
+		return getMessages(b, chatId)()
 	}
 }
 
+func flashTick() tea.Cmd {
+	return tea.Tick(500*time.Millisecond, func(t time.Time) tea.Msg {
+		return flashTickMsg{}
+	})
+}
 
-func deleteMessage(chatId, msgId string) error {
-	c := &http.Client{}
-	req, err := http.NewRequest(
-		http.MethodDelete,
-		fmt.Sprintf("%s/client/1/message/%s", baseURL, msgId),
-		nil,
-	)
+func flash(msg updateFlashMsg) tea.Cmd {
+	return func() tea.Msg {
+		return msg
+	}
+}
 
-	if err != nil {
-		return err
+func (msg *message) getMediaPrefix() string {
+	if msg.Type == "revoked" {
+		msg.HasMedia = true
+		return "[DELETED]"
+	} else if msg.Type == "ciphertext" {
+		msg.HasMedia = true
+		return "[VIS ONCE MEDIA]"
+	} else if msg.HasMedia {
+		return "[MEDIA]"
 	}
+	return ""
+}
+// setAck updates the delivery info of a message from a whatsapp ack level
+func (msg *message) setAck(ack int) {
+	if msg.Info == nil {
+		msg.Info = make(map[string]bool)
+	}
+	msg.Info["delivered"] = ack >= 2
+	msg.Info["read"] = ack >= 3
+	msg.Info["played"] = ack >= 4
+}
 
-	res, err := c.Do(req)
-	if err != nil {
-		return err
+func (msg *message) revoke() {
+	msg.Type = "revoked"
+	msg.Body = ""
+	msg.HasMedia = false
+}
+
+// selectMessage leaves the input with messages[idx] selected, scrolled to
+// the middle of the screen
+func (mp *messages_page) selectMessage(idx int) {
+	height := mp.messagesHeight()
+	mp.inInput = false
+	mp.selectedMsg = idx
+	mp.calculateMessageLines()
+
+	// curr_line is the last line of the selected message, like scroll_up
+	// and scroll_down keep it
+	mp.curr_line = -1
+	for i := 0; i <= idx; i++ {
+		str, _ := mp.renderMsg(mp.messages[i], i, "sender")
+		mp.curr_line += len(strings.Split(str, "\n"))
+	}
+	mp.scrollOffset = mp.curr_line - height/2
+	if maxScroll := len(mp.lines) - height; mp.scrollOffset > maxScroll {
+		mp.scrollOffset = maxScroll
+	}
+	if mp.scrollOffset < 0 {
+		mp.scrollOffset = 0
 	}
-	res.Body.Close()
-	return nil
 }
 
-func sendReply(chatId, text, responseToId string) tea.Cmd {
-	return func() tea.Msg {
-		data := map[string]string{
-			"message":        text,
-			"response_to_id": responseToId,
-		}
-		body, _ := json.Marshal(data)
-		res, err := http.Post(
-			fmt.Sprintf("%s/client/1/chat/%s/send", baseURL, chatId),
-			"application/json",
-			bytes.NewReader(body),
-		)
-		if err != nil {
-			return err
+// findMatches fills searchHits with the messages whose body or sender has
+// every word of searchQuery
+func (mp *messages_page) findMatches() {
+	mp.searchHits = nil
+	terms := searchTerms(mp.searchQuery)
+	if len(terms) == 0 {
+		return
+	}
+	for i, msg := range mp.messages {
+		if _, ok := matchMessage(terms, msg, mp.senderName(msg), ""); ok {
+			mp.searchHits = append(mp.searchHits, i)
 		}
-		io.Copy(io.Discard, res.Body)
-		res.Body.Close()
-		return getMessages(chatId)()
 	}
 }
 
-func sendMedia(chatId, mediaPath, caption, responseToId string) tea.Cmd {
-	return func() tea.Msg {
-		// Open file
-		file, err := os.Open(mediaPath)
-		if err != nil {
-			return updateFlashMsg{msg: "File not found!", count: 6}
+// searchFor runs a new search and selects the newest match
+func (mp *messages_page) searchFor(query string) {
+	mp.searchQuery = query
+	mp.findMatches()
+	if len(mp.searchHits) > 0 {
+		mp.selectMessage(mp.searchHits[len(mp.searchHits)-1])
+	}
+}
+
+// stepMatch selects the next match above the selected message, or below it
+// when older is false, wrapping around at the ends
+func (mp *messages_page) stepMatch(older bool) {
+	if len(mp.searchHits) == 0 {
+		return
+	}
+	from := mp.selectedMsg
+	if mp.inInput {
+		from = len(mp.messages)
+	}
+	next := -1
+	if older {
+		next = mp.searchHits[len(mp.searchHits)-1]
+		for i := len(mp.searchHits) - 1; i >= 0; i-- {
+			if mp.searchHits[i] < from {
+				next = mp.searchHits[i]
+				break
+			}
 		}
-		defer file.Close()
+	} else {
+		next = mp.searchHits[0]
+		for _, hit := range mp.searchHits {
+			if hit > from {
+				next = hit
+				break
+			}
+		}
+	}
+	mp.selectMessage(next)
+}
 
-		// Read first 512 bytes to detect MIME type
-		head := make([]byte, 512)
-		n, _ := file.Read(head)
-		mimeType := http.DetectContentType(head[:n])
+func (mp *messages_page) clearSearch() {
+	mp.searching = false
+	mp.searchQuery = ""
+	mp.searchHits = nil
+}
 
-		// Reset reader to beginning of file
-		if _, err := file.Seek(0, io.SeekStart); err != nil {
-			return error(err)
+func (mp messages_page) isMatch(idx int) bool {
+	for _, hit := range mp.searchHits {
+		if hit == idx {
+			return true
 		}
+	}
+	return false
+}
 
-		// Build multipart form
-		body := &bytes.Buffer{}
-		writer := multipart.NewWriter(body)
+// loadOlder fetches the page before the first message, once at a time
+func (mp *messages_page) loadOlder() {
+	if mp.loadingOlder || mp.noOlder || len(mp.messages) == 0 || mp.messages[0].MsgID == "" {
+		return
+	}
+	mp.loadingOlder = true
+	mp.container.commands = append(mp.container.commands, getOlderMessages(mp.container.app.current.backend, mp.from_chat.ID, mp.messages[0].MsgID))
+}
 
-		// Add message
-		_ = writer.WriteField("message", caption)
+// seekJump selects jumpToMsg once it is loaded, loading older pages until
+// it shows up or the history runs out
+func (mp *messages_page) seekJump() {
+	if mp.jumpToMsg == "" {
+		return
+	}
+	if _, idx := mp.findMessageByID(mp.jumpToMsg); idx != -1 {
+		mp.selectMessage(idx)
+		mp.jumpToMsg = ""
+		return
+	}
+	if mp.noOlder || len(mp.messages) == 0 || mp.messages[0].MsgID == "" {
+		mp.jumpToMsg = ""
+		return
+	}
+	mp.loadOlder()
+}
 
-		// Create form part with detected Content-Type
-		partHeader := textproto.MIMEHeader{}
-		partHeader.Set("Content-Disposition",
-			fmt.Sprintf(`form-data; name="media"; filename="%s"`, filepath.Base(mediaPath)))
-		partHeader.Set("Content-Type", mimeType)
+// prependMessages adds older history on top, keeping the same messages
+// selected and on screen
+func (mp *messages_page) prependMessages(older []message) {
+	mp.calculateMessageLines()
+	linesBefore := len(mp.lines)
 
-		part, err := writer.CreatePart(partHeader)
-		if err != nil {
-			return error(err)
-		}
-		if _, err := io.Copy(part, file); err != nil {
-			return error(err)
+	n := len(older)
+	mp.messages = append(older, mp.messages...)
+	if mp.selectedMsg >= 0 {
+		mp.selectedMsg += n
+	}
+	if mp.visualFrom != -1 {
+		mp.visualFrom += n
+	}
+	if mp.replyingToMsg != -1 {
+		mp.replyingToMsg += n
+	}
+	highlights := make(map[int]bool, len(mp.replyHighlights))
+	for i, on := range mp.replyHighlights {
+		highlights[i+n] = on
+	}
+	mp.replyHighlights = highlights
+	mp.findMatches()
+
+	mp.calculateMessageLines()
+	added := len(mp.lines) - linesBefore
+	mp.curr_line += added
+	mp.scrollOffset += added
+}
+
+// jumpToQuoted selects the message the selected one replies to
+func (mp *messages_page) jumpToQuoted() {
+	if !mp.inInput && mp.selectedMsg >= 0 && mp.selectedMsg < len(mp.messages) {
+		selected := mp.messages[mp.selectedMsg]
+		if selected.ResponseToID != "" {
+			_, idx := mp.findMessageByID(selected.ResponseToID)
+			if idx != -1 {
+				mp.selectMessage(idx)
+			}
 		}
+	}
+}
+
+// openMedia opens the media of the selected message, false when it has none
+func (mp *messages_page) openMedia() bool {
+	if mp.inInput || mp.selectedMsg < 0 || !mp.messages[mp.selectedMsg].HasMedia {
+		return false
+	}
+	mediaURL := mp.container.app.current.backend.MediaURL(mp.messages[mp.selectedMsg].MsgID)
+	if mediaURL != "" {
+		openURL(mediaURL)
+	}
+	return true
+}
+
+// click selects the message under the mouse where it is on screen. Clicking
+// a [MEDIA] label opens the media and a [REPLY: ...] preview jumps to the
+// quoted message
+func (mp *messages_page) click(msg tea.MouseMsg) {
+	mp.calculateMessageLines()
+	start, end := mp.window()
+	row, col := rowAt("messages", start, end, msg)
+	if row == -1 {
+		return
+	}
+	line := mp.lines[row]
+	mp.selectMessage(mp.lineMsg[row])
+	mp.scrollOffset = start
+
+	switch {
+	case labelAt(line, col, "[REPLY:"):
+		mp.jumpToQuoted()
+	case labelAt(line, col, "[MEDIA]"):
+		mp.openMedia()
+	}
+}
+
+// submitInput sends what was typed, as a reply when replying. media:"path"
+// sends a file and media:clipboard the copied image, the rest is the caption
+func (mp *messages_page) submitInput() {
+	input := strings.TrimSpace(mp.input.Value())
+	if mp.editingMsg != "" {
+		mp.submitEdit(input)
+		return
+	}
+	if input == "" && len(mp.attachments) == 0 {
+		return
+	}
+
+	var cmd tea.Cmd
+
+	// Clear input field
+	if input != "" {
+		mp.container.app.current.remember(input)
+	}
+	mp.input.Reset()
+	mp.historyIdx = -1
 
-		// Add response_to_id
-		_ = writer.WriteField("response_to_id", responseToId)
+	// Files picked with attach_files go with the input as their caption
+	if len(mp.attachments) > 0 {
+		var replyToID string
+		if mp.replyingToMsg != -1 && mp.replyingToMsg < len(mp.messages) {
+			replyToID = mp.messages[mp.replyingToMsg].MsgID
+			mp.replyHighlights = make(map[int]bool)
+			mp.replyingToMsg = -1
+		}
+		mp.scrollOffset = 0
+		cmd = sendAttachments(mp.container.app.current.backend, mp.from_chat.ID, mp.attachments, input, replyToID)
+		mp.attachments = nil
+		mp.container.commands = append(mp.container.commands, cmd)
+		return
+	}
 
-		// Close form
-		if err := writer.Close(); err != nil {
-			return error(err)
+	// Handle media syntax
+	if strings.HasPrefix(input, `media:"`) {
+		parts := strings.SplitN(input[len(`media:"`):], `"`, 2)
+		if len(parts) < 1 {
+			return
+		}
+		mediaPath := parts[0]
+		caption := ""
+		if len(parts) == 2 {
+			caption = strings.TrimSpace(parts[1])
 		}
 
-		// Prepare and send request
-		url := fmt.Sprintf("%s/client/1/chat/%s/send", baseURL, chatId)
-		req, err := http.NewRequest("POST", url, body)
-		if err != nil {
-			return error(err)
+		var replyToID string
+		if mp.replyingToMsg != -1 {
+			replyToID = mp.messages[mp.replyingToMsg].MsgID
+			mp.replyHighlights = make(map[int]bool)
+			mp.replyingToMsg = -1
 		}
-		req.Header.Set("Content-Type", writer.FormDataContentType())
 
-		res, err := http.DefaultClient.Do(req)
-		if err != nil {
-			return error(err)
+		mp.scrollOffset = 0
+
+		cmd = sendMedia(mp.container.app.current.backend, mp.from_chat.ID, mediaPath, caption, replyToID)
+		mp.container.commands = append(mp.container.commands, cmd)
+		return
+	}
+
+	// Handle clipboard media
+	if strings.HasPrefix(input, "media:clipboard") {
+		caption := ""
+		parts := strings.SplitN(input, " ", 2)
+		if len(parts) > 1 {
+			caption = strings.TrimSpace(parts[1])
 		}
-		defer res.Body.Close()
 
-		if res.StatusCode >= 400 {
-			return error(fmt.Errorf("server error: %d", res.StatusCode))
+		var replyToID string
+		if mp.replyingToMsg != -1 {
+			replyToID = mp.messages[mp.replyingToMsg].MsgID
+			mp.replyHighlights = make(map[int]bool)
+			mp.replyingToMsg = -1
 		}
+		mp.scrollOffset = 0
 
-		return getMessages(chatId)()
+		b := mp.container.app.current.backend
+		cmd = func() tea.Msg {
+			mediaPath, err := getClipboardMediaFile()
+			if err != nil {
+				return updateFlashMsg{msg: "Clipboard: " + err.Error(), count: 6}
+			}
+			defer os.Remove(mediaPath)
+			return sendMedia(b, mp.from_chat.ID, mediaPath, caption, replyToID)()
+		}
+		mp.container.commands = append(mp.container.commands, cmd)
+		return
 	}
-}
-func flashTick() tea.Cmd {
-	return tea.Tick(500*time.Millisecond, func(t time.Time) tea.Msg {
-		return flashTickMsg{}
-	})
-}
 
-func flash(msg updateFlashMsg) tea.Cmd {
-	return func() tea.Msg {
-		return msg
+	// Handle reply or plain message
+	if mp.replyingToMsg != -1 && mp.replyingToMsg < len(mp.messages) {
+		replyToID := mp.messages[mp.replyingToMsg].MsgID
+		mp.replyHighlights = make(map[int]bool)
+		mp.replyingToMsg = -1
+		mp.scrollOffset = 0
+
+		cmd = sendReply(mp.container.app.current.backend, mp.from_chat.ID, input, replyToID)
+	} else {
+		cmd = sendMessage(mp.container.app.current.backend, mp.from_chat.ID, input)
+		mp.scrollOffset = 0
 	}
+
+	mp.container.commands = append(mp.container.commands, cmd)
 }
 
-func (msg *message) getMediaPrefix() string {
-	if msg.Type == "revoked" {
-		msg.HasMedia = true
-		return "[DELETED]"
-	} else if msg.Type == "ciphertext" {
-		msg.HasMedia = true
-		return "[VIS ONCE MEDIA]"
-	} else if msg.HasMedia {
-		return "[MEDIA]"
+// forwardIDs are the selected messages in the order they were sent
+func (mp messages_page) forwardIDs() []string {
+	var ids []string
+	for _, idx := range mp.selection() {
+		if id := mp.messages[idx].MsgID; id != "" {
+			ids = append(ids, id)
+		}
 	}
-	return ""
+	return ids
 }
+
 func (mp messages_page) getOfsset(idx int) int {
 	offset := 0
 	for i := 0; i < idx; i++ {
@@ -414,21 +762,27 @@ func (mp messages_page) getOfsset(idx int) int {
 
 func (mp *messages_page) registerLuaFuncs() {
 	L := mp.container.app.luaState
+	mp.registerReactionFuncs()
+	mp.registerDeleteFuncs()
+	mp.registerSelectionFuncs()
+	mp.registerAttachFuncs()
 
 	L.SetGlobal("scroll_up", L.NewFunction(func(L *lua.LState) int {
 		mp.calculateMessageLines()
 		if mp.inInput {
 			mp.inInput = false
 			mp.selectedMsg = len(mp.messages) - 1
-			mp.scrollOffset = len(mp.lines) - (mp.container.app.height - 2)
+			mp.scrollOffset = len(mp.lines) - mp.messagesHeight()
 			mp.curr_line = len(mp.lines) - 1
 		} else if mp.selectedMsg > 0 {
 			str, _ := mp.renderMsg(mp.messages[mp.selectedMsg], mp.selectedMsg, "sender")
 			mp.curr_line -= (len(strings.Split(str, "\n")))
 			mp.selectedMsg--
 			if mp.curr_line < mp.scrollOffset {
-				mp.scrollOffset = mp.curr_line - (mp.container.app.height - 2)
+				mp.scrollOffset = mp.curr_line - mp.messagesHeight()
 			}
+		} else if mp.selectedMsg == 0 {
+			mp.loadOlder()
 		}
 		return 0
 	}))
@@ -440,8 +794,8 @@ func (mp *messages_page) registerLuaFuncs() {
 				str, _ := mp.renderMsg(mp.messages[mp.selectedMsg], mp.selectedMsg, "sender")
 				mp.curr_line += len(strings.Split(str, "\n"))
 				mp.selectedMsg++
-				if mp.curr_line >= mp.scrollOffset+(mp.container.app.height-2) {
-					mp.scrollOffset = mp.curr_line - (mp.container.app.height - 2) + 1
+				if mp.curr_line >= mp.scrollOffset+mp.messagesHeight() {
+					mp.scrollOffset = mp.curr_line - mp.messagesHeight() + 1
 				}
 			} else {
 				mp.inInput = true
@@ -455,9 +809,38 @@ func (mp *messages_page) registerLuaFuncs() {
 	}))
 
 	L.SetGlobal("escape", L.NewFunction(func(L *lua.LState) int {
+		if mp.attaching != nil {
+			mp.attaching = nil
+			return 0
+		}
+		if mp.deleting != nil {
+			mp.deleting = nil
+			return 0
+		}
+		if mp.reactPicker != nil {
+			mp.reactPicker = nil
+			return 0
+		}
+		if mp.searching || mp.searchQuery != "" {
+			mp.clearSearch()
+			return 0
+		}
+		if mp.editingMsg != "" {
+			mp.stopEdit()
+			return 0
+		}
+		if mp.visualFrom != -1 {
+			mp.visualFrom = -1
+			return 0
+		}
+		if mp.inInput && len(mp.attachments) > 0 {
+			mp.attachments = nil
+			return 0
+		}
 		if !mp.inInput || mp.replyingToMsg != -1 {
 			mp.replyingToMsg = -1
 			mp.replyHighlights = make(map[int]bool)
+			mp.clearSelection()
 			mp.selectedMsg = -1
 			mp.inInput = true
 			return 0
@@ -468,32 +851,7 @@ func (mp *messages_page) registerLuaFuncs() {
 	}))
 
 	L.SetGlobal("jump_to_quoted", L.NewFunction(func(L *lua.LState) int {
-		if !mp.inInput && mp.selectedMsg >= 0 && mp.selectedMsg < len(mp.messages) {
-			selected := mp.messages[mp.selectedMsg]
-			if selected.ResponseToID != "" {
-				_, idx := mp.findMessageByID(selected.ResponseToID)
-				if idx != -1 {
-					mp.selectedMsg = idx
-					height := mp.container.app.height - 2
-					if height < 1 {
-						height = 1
-					}
-					mp.scrollOffset = idx - height/2
-					if mp.scrollOffset < 0 {
-						mp.scrollOffset = 0
-					}
-					mp.calculateMessageLines()
-					lines := mp.lines
-					maxScroll := len(lines) - height
-					if mp.scrollOffset > maxScroll {
-						mp.scrollOffset = maxScroll
-					}
-					if mp.scrollOffset < 0 {
-						mp.scrollOffset = 0
-					}
-				}
-			}
-		}
+		mp.jumpToQuoted()
 		return 0
 	}))
 
@@ -515,146 +873,163 @@ func (mp *messages_page) registerLuaFuncs() {
 	}))
 
 	L.SetGlobal("open_media", L.NewFunction(func(L *lua.LState) int {
-		if !mp.inInput && mp.selectedMsg >= 0 && mp.messages[mp.selectedMsg].HasMedia {
-			mediaURL := fmt.Sprintf("%s/client/1/message/%s/media", baseURL, mp.messages[mp.selectedMsg].MsgID)
-			openURL(mediaURL)
-		} else {
+		if !mp.openMedia() {
 			mp.container.app.luaReturn = "type"
 		}
 		return 0
 	}))
 
+	// save_media(open, command) saves the media to config.downloads_dir,
+	// then opens it with command (config.open_with without one) when open
+	L.SetGlobal("save_media", L.NewFunction(func(L *lua.LState) int {
+		if !mp.saveSelectedMedia(L.ToBool(1), L.OptString(2, "")) {
+			mp.container.app.luaReturn = "type"
+		}
+		return 0
+	}))
+
+	L.SetGlobal("click", L.NewFunction(func(L *lua.LState) int {
+		if mp.container.mouse != nil {
+			mp.click(*mp.container.mouse)
+		}
+		return 0
+	}))
+
+	L.SetGlobal("mark_selected", L.NewFunction(func(L *lua.LState) int {
+		if mp.inInput || mp.selectedMsg < 0 || mp.selectedMsg >= len(mp.messages) {
+			mp.container.app.luaReturn = "type"
+			return 0
+		}
+		id := mp.messages[mp.selectedMsg].MsgID
+		if mp.marked[id] {
+			delete(mp.marked, id)
+		} else if id != "" {
+			mp.marked[id] = true
+		}
+		return 0
+	}))
 	L.SetGlobal("forward_selected", L.NewFunction(func(L *lua.LState) int {
 		if !mp.inInput && mp.selectedMsg >= 0 {
-			cp := new_chats_page(mp.container)
-			cp.forwarding.isForwarding = true
-			cp.forwarding.MsgID = mp.messages[mp.selectedMsg].MsgID
-			mp.container.page = cp
-			mp.container.commands = append(mp.container.commands, getChats())
+			mp.container.app.luaReturn = "go_forward"
 		} else {
 			mp.container.app.luaReturn = "type"
 		}
 
 		return 0
 	}))
-	L.SetGlobal("delete_selected", L.NewFunction(func(L *lua.LState) int {
-		if !mp.inInput && mp.selectedMsg >= 0 {
-			err := deleteMessage(mp.from_chat.ID, mp.messages[mp.selectedMsg].MsgID)
-			if err != nil {
-				mp.container.app.flashMsg = "ERROR when deleting msg"
-				mp.container.app.flashCount = 6
-			} else {
-				time.Sleep(3 * time.Second)
-				mp.container.commands = append(mp.container.commands, getMessages(mp.from_chat.ID))
-			}
+	L.SetGlobal("edit_selected", L.NewFunction(func(L *lua.LState) int {
+		if !mp.inInput && mp.selectedMsg >= 0 && mp.selectedMsg < len(mp.messages) {
+			mp.startEdit()
 		} else {
 			mp.container.app.luaReturn = "type"
 		}
-
 		return 0
 	}))
-
 	L.SetGlobal("append_input", L.NewFunction(func(L *lua.LState) int {
 		str := L.ToString(1)
-		mp.input += str
+		mp.input.InsertString(str)
+		return 0
+	}))
+	L.SetGlobal("newline_input", L.NewFunction(func(L *lua.LState) int {
+		mp.input.InsertRune('\n')
+		return 0
+	}))
+	L.SetGlobal("input_up", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LBool(mp.inInput && mp.inputUp()))
+		return 1
+	}))
+	L.SetGlobal("input_down", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LBool(mp.inInput && mp.inputDown()))
+		return 1
+	}))
+	L.SetGlobal("history_prev", L.NewFunction(func(L *lua.LState) int {
+		mp.inInput = true
+		mp.stepHistory(true)
+		return 0
+	}))
+	L.SetGlobal("history_next", L.NewFunction(func(L *lua.LState) int {
+		mp.inInput = true
+		mp.stepHistory(false)
+		return 0
+	}))
+	L.SetGlobal("edit_input_external", L.NewFunction(func(L *lua.LState) int {
+		mp.inInput = true
+		cmd := editExternally(mp.container.app.current, mp.input.Value(), mp.from_chat.ID, L.ToBool(1))
+		mp.container.commands = append(mp.container.commands, cmd)
 		return 0
 	}))
 	L.SetGlobal("backspace_input", L.NewFunction(func(L *lua.LState) int {
-		if len(mp.input) > 0 {
-			mp.input = mp.input[:len(mp.input)-1]
+		if mp.searching {
+			if runes := []rune(mp.searchQuery); len(runes) > 0 {
+				mp.searchFor(string(runes[:len(runes)-1]))
+			}
+			return 0
 		}
+		mp.editInput(tea.KeyMsg{Type: tea.KeyBackspace})
 		return 0
 	}))
 
 	L.SetGlobal("submit_input", L.NewFunction(func(L *lua.LState) int {
-		input := strings.TrimSpace(mp.input)
-		if input == "" {
-			return 0
-		}
-
-		var cmd tea.Cmd
-
-		// Clear input field
-		mp.input = ""
-
-		// Handle media syntax
-		if strings.HasPrefix(input, `media:"`) {
-			parts := strings.SplitN(input[len(`media:"`):], `"`, 2)
-			if len(parts) < 1 {
-				return 0
-			}
-			mediaPath := parts[0]
-			caption := ""
-			if len(parts) == 2 {
-				caption = strings.TrimSpace(parts[1])
-			}
-
-			var replyToID string
-			if mp.replyingToMsg != -1 {
-				replyToID = mp.messages[mp.replyingToMsg].MsgID
-				mp.replyHighlights = make(map[int]bool)
-				mp.replyingToMsg = -1
+		if mp.searching {
+			mp.searching = false
+			if len(mp.searchHits) == 0 {
+				mp.clearSearch()
+				mp.container.commands = append(mp.container.commands, flash(updateFlashMsg{msg: "No messages match", count: 6}))
 			}
-
-			mp.scrollOffset = 0
-
-			cmd = sendMedia(mp.from_chat.ID, mediaPath, caption, replyToID)
-			mp.container.commands = append(mp.container.commands, cmd)
 			return 0
 		}
+		mp.submitInput()
+		return 0
+	}))
 
-		// Handle clipboard media
-		if strings.HasPrefix(input, "media:clipboard") {
-			caption := ""
-			parts := strings.SplitN(input, " ", 2)
-			if len(parts) > 1 {
-				caption = strings.TrimSpace(parts[1])
-			}
+	L.SetGlobal("in_input", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LBool(mp.inInput))
+		return 1
+	}))
 
-			var replyToID string
-			if mp.replyingToMsg != -1 {
-				replyToID = mp.messages[mp.replyingToMsg].MsgID
-				mp.replyHighlights = make(map[int]bool)
-				mp.replyingToMsg = -1
-			}
-			mp.scrollOffset = 0
+	L.SetGlobal("input_content", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LString(mp.input.Value()))
+		return 1
+	}))
 
-			cmd = func() tea.Msg {
-				mediaPath, err := getClipboardMediaFile()
-				if err != nil {
-					return updateFlashMsg{msg: "Clipboard: " + err.Error(), count: 6}
-				}
-				defer os.Remove(mediaPath)
-				return sendMedia(mp.from_chat.ID, mediaPath, caption, replyToID)()
-			}
-			mp.container.commands = append(mp.container.commands, cmd)
+	L.SetGlobal("start_search", L.NewFunction(func(L *lua.LState) int {
+		// in the input the key is typed, messages may start with it
+		if mp.inInput {
+			mp.container.app.luaReturn = "type"
 			return 0
 		}
+		mp.clearSearch()
+		mp.searching = true
+		return 0
+	}))
 
-		// Handle reply or plain message
-		if mp.replyingToMsg != -1 && mp.replyingToMsg < len(mp.messages) {
-			replyToID := mp.messages[mp.replyingToMsg].MsgID
-			mp.replyHighlights = make(map[int]bool)
-			mp.replyingToMsg = -1
-			mp.scrollOffset = 0
+	L.SetGlobal("search_messages", L.NewFunction(func(L *lua.LState) int {
+		mp.searching = false
+		mp.searchFor(L.ToString(1))
+		L.Push(lua.LNumber(len(mp.searchHits)))
+		return 1
+	}))
 
-			cmd = sendReply(mp.from_chat.ID, input, replyToID)
-		} else {
-			cmd = sendMessage(mp.from_chat.ID, input)
-			mp.scrollOffset = 0
+	L.SetGlobal("search_next", L.NewFunction(func(L *lua.LState) int {
+		if mp.inInput || mp.searchQuery == "" {
+			mp.container.app.luaReturn = "type"
+			return 0
 		}
-
-		mp.container.commands = append(mp.container.commands, cmd)
+		mp.stepMatch(true)
 		return 0
 	}))
 
-	L.SetGlobal("in_input", L.NewFunction(func(L *lua.LState) int {
-		L.Push(lua.LBool(mp.inInput))
-		return 1
+	L.SetGlobal("search_prev", L.NewFunction(func(L *lua.LState) int {
+		if mp.inInput || mp.searchQuery == "" {
+			mp.container.app.luaReturn = "type"
+			return 0
+		}
+		mp.stepMatch(false)
+		return 0
 	}))
 
-	L.SetGlobal("input_content", L.NewFunction(func(L *lua.LState) int {
-		L.Push(lua.LString(mp.input))
+	L.SetGlobal("highlight_search", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LString(highlightMatches(L.ToString(1), searchTerms(mp.searchQuery))))
 		return 1
 	}))
 
@@ -684,29 +1059,51 @@ func (mp *messages_page) registerLuaFuncs() {
 func (mp messages_page) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	mp.registerLuaFuncs()
 	mp.container.app.luaReturn = "" // Reset Lua return value
+	// whatever is left in the input is the draft of the chat, and the
+	// previews of what is shown are loaded
+	defer func() {
+		mp.container.app.current.setDraft(mp.from_chat.ID, mp.draft())
+		mp.loadPreviews()
+	}()
 	switch msg := msg.(type) {
-	case tea.KeyMsg:
-		key := msg.String()
+	case tea.KeyMsg, tea.MouseMsg:
+		key := msg.(fmt.Stringer).String()
 
 		// Look for Lua keybind
 		L := mp.container.app.luaState
 		luaKeyHandled := false
 
+		// the search prompt has its own keybinds while it is open
+		keybinds := "message_keybinds"
+		if mp.searching {
+			keybinds = "message_search_keybinds"
+		} else if mp.attaching != nil {
+			keybinds = "message_attach_keybinds"
+		} else if mp.deleting != nil {
+			keybinds = "message_delete_keybinds"
+		} else if mp.reactPicker != nil {
+			keybinds = "message_react_keybinds"
+		}
+
 		// Try to run keybinds[key]()
 		err := L.DoString(fmt.Sprintf(`
+			handled = false
 			local key = %q
-			local f = message_keybinds[key]
+			local f = (%s or {})[key]
 			if type(f) == "function" then
 				f()
 				handled = true
 			end
-		`, key))
+		`, key, keybinds))
 		if err != nil {
 			fmt.Println("Lua error:", err)
 		}
 
 		luaKeyHandled = L.GetGlobal("handled") == lua.LTrue
 		L.SetGlobal("handled", lua.LBool(false)) // reset
+		if !luaKeyHandled && (mp.deleting != nil || mp.attaching != nil || keybinds == "message_react_keybinds") {
+			return mp, nil // nothing is typed while picking
+		}
 		if !luaKeyHandled {
 			mp.container.app.luaReturn = "type" // No Lua keybind handled, return to input mode
 		}
@@ -714,20 +1111,50 @@ func (mp messages_page) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if mp.container.app.luaReturn != "" {
 			switch mp.container.app.luaReturn {
 			case "type":
-				mp.input += key
+				// the mouse never types
+				keyMsg, ok := msg.(tea.KeyMsg)
+				if !ok {
+					break
+				}
+				if !mp.searching {
+					mp.editInput(keyMsg)
+					break
+				}
+				// the runes, key wraps pasted text in brackets
+				switch keyMsg.Type {
+				case tea.KeyRunes:
+					mp.searchFor(mp.searchQuery + string(keyMsg.Runes))
+				case tea.KeySpace:
+					mp.searchFor(mp.searchQuery + " ")
+				}
 
 			case "go_chats":
+				mp.container.app.current.setDraft(mp.from_chat.ID, mp.draft())
+				mp.container.app.current.saveDrafts()
+				return mp.container.pop(mp.container.chatsFallback), nil
+
+			case "go_forward":
 				cp := new_chats_page(mp.container)
-				mp.container.commands = append(mp.container.commands, getChats())
-				return cp, nil
+				cp.forwarding.isForwarding = true
+				cp.forwarding.MsgIDs = mp.forwardIDs()
+				cp.forwarding.marked = make(map[string]bool)
+				mp.clearSelection()
+				mp.container.commands = append(mp.container.commands, getChats(mp.container.app.current.backend))
+				return mp.container.push(mp, cp), nil
 			}
 		}
 
 		return mp, nil
 
 	case messagesLoadedMsg:
+		// a load for the chat that was open before may land after leaving it
+		if msg.chatID != mp.from_chat.ID {
+			return mp, nil
+		}
 		mp.container.app.luaState.OpenLibs()
-		mp.messages = msg
+		mp.messages = keepOlderMessages(mp.messages, msg.msgs)
+		mp.findMatches()
+		mp.seekJump()
 		if strings.Contains(mp.from_chat.ID, "@g.us") {
 			// Group chat
 			chatTitle := mp.from_chat.Name
@@ -735,9 +1162,9 @@ func (mp messages_page) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				chatTitle = mp.from_chat.ID
 			}
 			setTerminalTitle("Whats-CLI: " + chatTitle)
-		} else if len(msg) > 0 {
+		} else if len(msg.msgs) > 0 {
 			// Private chat — get the 'From' field of first non-self message
-			for _, message := range msg {
+			for _, message := range msg.msgs {
 				if !message.FromMe {
 					displayName := mp.from_chat.Name
 					if displayName == "" {
@@ -783,12 +1210,126 @@ func (mp messages_page) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			mp.container.commands = append(mp.container.commands, flash(updateFlashMsg{msg: "MSG FROM " + msg.Chat.Name, count: 6}))
 			return mp, nil
 		}
-		mp.container.commands = append(mp.container.commands, getMessages(msg.Chat.ID))
+		mp.container.commands = append(mp.container.commands, getMessages(mp.container.app.current.backend, msg.Chat.ID))
 		return mp, nil
+
+	case externalEditMsg:
+		if msg.chatID != mp.from_chat.ID {
+			return mp, nil
+		}
+		if msg.err != nil {
+			mp.container.commands = append(mp.container.commands, flash(updateFlashMsg{msg: "Editor failed: " + msg.err.Error(), count: 6}))
+			return mp, nil
+		}
+		// editors end files with a newline
+		mp.input.SetValue(strings.TrimRight(msg.text, "\n"))
+		mp.historyIdx = -1
+		mp.sizeComposer()
+		if msg.send {
+			mp.submitInput()
+		}
+		return mp, nil
+
+	case olderMessagesMsg:
+		if msg.chatID != mp.from_chat.ID {
+			return mp, nil
+		}
+		mp.loadingOlder = false
+		if msg.err != nil {
+			mp.jumpToMsg = ""
+			mp.container.commands = append(mp.container.commands, flash(updateFlashMsg{msg: "Could not load older messages", count: 6}))
+			return mp, nil
+		}
+		// the history may have moved while the page was loading
+		if len(mp.messages) == 0 || mp.messages[0].MsgID != msg.before {
+			mp.seekJump()
+			return mp, nil
+		}
+		var older []message
+		for _, m := range msg.msgs {
+			if _, idx := mp.findMessageByID(m.MsgID); idx == -1 && m.MsgID != "" {
+				older = append(older, m)
+			}
+		}
+		if len(older) == 0 {
+			mp.noOlder = true
+			mp.seekJump()
+			return mp, nil
+		}
+		mp.prependMessages(older)
+		mp.seekJump()
+
+	case msgAckMsg:
+		if i := mp.indexOfEvent(msg.ChatID, msg.MsgID); i != -1 {
+			mp.messages[i].setAck(msg.Ack)
+		}
+	case msgRevokedMsg:
+		if i := mp.indexOfEvent(msg.ChatID, msg.MsgID); i != -1 {
+			mp.messages[i].revoke()
+		}
+	case msgEditedMsg:
+		if i := mp.indexOfEvent(msg.ChatID, msg.MsgID); i != -1 {
+			mp.messages[i].edit(msg.Body)
+		}
+	case messageDeletedMsg:
+		if msg.chatID == mp.from_chat.ID {
+			mp.deleteDone(msg)
+		}
+	case reactedMsg:
+		if msg.chatID == mp.from_chat.ID {
+			mp.reactDone(msg)
+		}
+	case editedMsg:
+		if msg.chatID == mp.from_chat.ID {
+			mp.editDone(msg)
+		}
+	case reactionMsg:
+		if i := mp.indexOfEvent(msg.ChatID, msg.MsgID); i != -1 {
+			mp.messages[i].react(msg.SenderID, msg.Reaction, false)
+			if i == mp.selectedMsg {
+				mp.keepSelectedVisible()
+			}
+		}
+	case groupMembersMsg:
+		if msg.ChatID == mp.from_chat.ID {
+			names := make([]string, 0, len(msg.Participants))
+			for _, id := range msg.Participants {
+				if name, ok := mp.container.app.current.id_to_name[id]; ok {
+					id = name
+				}
+				names = append(names, id)
+			}
+			flashText := fmt.Sprintf("GROUP %s: %s", strings.ToUpper(msg.Action), strings.Join(names, ", "))
+			mp.container.commands = append(mp.container.commands, flash(updateFlashMsg{msg: flashText, count: 6}))
+		}
 	}
 	return mp, nil
 }
 
+// indexOfEvent finds the message an event is about, if it is in this chat
+func (mp messages_page) indexOfEvent(chatID, msgID string) int {
+	if chatID != "" && chatID != mp.from_chat.ID {
+		return -1
+	}
+	_, idx := mp.findMessageByID(msgID)
+	return idx
+}
+
+// quotedPreview is the start of the message msg replies to, with its media
+// indicator
+func (mp messages_page) quotedPreview(msg message) string {
+	originalMsg, _ := mp.findMessageByID(msg.ResponseToID)
+	originalBody := originalMsg.Body
+	if originalMsg.HasMedia {
+		originalBody = originalMsg.getMediaPrefix() + originalBody
+	}
+	// Truncate original body if too long (adjust as needed)
+	if len(originalBody) > 30 {
+		originalBody = originalBody[:27] + "..."
+	}
+	return originalBody
+}
+
 func (mp messages_page) renderMsg(msg message, idx int, sender string) (string, bool) {
 	L := mp.container.app.luaState
 
@@ -797,6 +1338,15 @@ func (mp messages_page) renderMsg(msg message, idx int, sender string) (string,
 		Width       int    `json:"width"`
 		Height      int    `json:"height"`
 		Name        string `json:"name"`
+		Search      string `json:"search"`
+		Is_match    bool   `json:"is_match"`
+		Quoted      string `json:"quoted,omitempty"`
+		Reactions   string `json:"reactions,omitempty"`
+		Is_marked   bool   `json:"is_marked"`
+		Selecting   bool   `json:"selecting"`
+		Selected    int    `json:"selected_count"`
+		Preview     string `json:"preview,omitempty"`
+		PreviewW    int    `json:"preview_width,omitempty"`
 	}
 	type message_to_render struct {
 		Msg  message                `json:"message"`
@@ -805,6 +1355,14 @@ func (mp messages_page) renderMsg(msg message, idx int, sender string) (string,
 	luaHandled := false
 	renderedLine := ""
 
+	markedCount := mp.markedCount()
+	preview, previewWidth := mp.preview(msg)
+
+	var quoted string
+	if msg.ResponseToID != "" {
+		quoted = mp.quotedPreview(msg)
+	}
+
 	str, err := struct_to_lua_table(
 		message_to_render{
 			Msg: msg,
@@ -813,6 +1371,15 @@ func (mp messages_page) renderMsg(msg message, idx int, sender string) (string,
 				Width:       mp.container.app.width,
 				Height:      mp.container.app.height,
 				Name:        sender,
+				Search:      mp.searchQuery,
+				Is_match:    mp.isMatch(idx),
+				Quoted:      quoted,
+				Reactions:   reactionSummary(msg.Reactions),
+				Is_marked:   mp.inSelection(idx),
+				Selecting:   mp.visualFrom != -1,
+				Selected:    markedCount,
+				Preview:     preview,
+				PreviewW:    previewWidth,
 			},
 		})
 	if err != nil {
@@ -855,7 +1422,13 @@ func (mp messages_page) renderMsg(msg message, idx int, sender string) (string,
 	ts := msg.Timestamp.Local().Format("15:04")
 
 	body := msg.Body
+	if msg.IsEdited {
+		body += " (edited)"
+	}
 	msgPrefix := "[" + ts + "] <" + sender + ">: "
+	if mp.inSelection(idx) {
+		msgPrefix = "[x] " + msgPrefix
+	}
 
 	// Calculate the full prefix length (line prefix + message prefix)
 	fullPrefixLength := utf8.RuneCountInString(linePrefix + msgPrefix)
@@ -863,17 +1436,7 @@ func (mp messages_page) renderMsg(msg message, idx int, sender string) (string,
 	// Handle reply indicator
 	var replyPrefix string
 	if msg.ResponseToID != "" {
-		// Find the original message body (now includes media indicator)
-		originalMsg, _ := mp.findMessageByID(msg.ResponseToID)
-		originalBody := originalMsg.Body
-		if originalMsg.HasMedia {
-			originalBody = originalMsg.getMediaPrefix() + originalBody
-		}
-		// Truncate original body if too long (adjust as needed)
-		if len(originalBody) > 30 {
-			originalBody = originalBody[:27] + "..."
-		}
-		replyPrefix = fmt.Sprintf("[REPLY: %s] ", originalBody)
+		replyPrefix = fmt.Sprintf("[REPLY: %s] ", mp.quotedPreview(msg))
 	}
 
 	// Handle media indicator
@@ -893,6 +1456,12 @@ func (mp messages_page) renderMsg(msg message, idx int, sender string) (string,
 	hasReplyHighlight := mp.replyHighlights[idx] || (mp.replyingToMsg == idx)
 	selected := mp.selectedMsg == idx && !mp.inInput
 
+	// With a search open the words matching it are highlighted in the body
+	var terms []string
+	if mp.isMatch(idx) && !hasReplyHighlight && !selected {
+		terms = searchTerms(mp.searchQuery)
+	}
+
 	// Apply styling to msgPrefix if it's from me (unless reply highlighted)
 	styledMsgPrefix := msgPrefix
 	if !hasReplyHighlight && !selected && msg.FromMe {
@@ -934,14 +1503,14 @@ func (mp messages_page) renderMsg(msg message, idx int, sender string) (string,
 						mediaLabel := mediaPrefix
 						afterMedia := strings.TrimPrefix(rest, mediaLabel)
 						if msg.FromMe {
-							firstLine = styledReplyIndicator + styles["hyperlink"].Render(mediaLabel) + styles["selfBody"].Render(afterMedia)
+							firstLine = styledReplyIndicator + styles["hyperlink"].Render(mediaLabel) + highlightStyled(afterMedia, terms, styles["selfBody"])
 						} else {
-							firstLine = styledReplyIndicator + styles["hyperlink"].Render(mediaLabel) + afterMedia
+							firstLine = styledReplyIndicator + styles["hyperlink"].Render(mediaLabel) + highlightMatches(afterMedia, terms)
 						}
 					} else if msg.FromMe {
-						firstLine = styledReplyIndicator + styles["selfBody"].Render(rest)
+						firstLine = styledReplyIndicator + highlightStyled(rest, terms, styles["selfBody"])
 					} else {
-						firstLine = styledReplyIndicator + rest
+						firstLine = styledReplyIndicator + highlightMatches(rest, terms)
 					}
 				}
 			} else if strings.HasPrefix(firstLine, mediaPrefix) {
@@ -949,13 +1518,15 @@ func (mp messages_page) renderMsg(msg message, idx int, sender string) (string,
 				mediaLabel := mediaPrefix
 				rest := strings.TrimPrefix(firstLine, mediaLabel)
 				if msg.FromMe {
-					firstLine = styles["hyperlink"].Render(mediaLabel) + styles["selfBody"].Render(rest)
+					firstLine = styles["hyperlink"].Render(mediaLabel) + highlightStyled(rest, terms, styles["selfBody"])
 				} else {
-					firstLine = styles["hyperlink"].Render(mediaLabel) + rest
+					firstLine = styles["hyperlink"].Render(mediaLabel) + highlightMatches(rest, terms)
 				}
 			} else if msg.FromMe {
 				// For self messages without media or reply, apply self styling
-				firstLine = styles["selfBody"].Render(firstLine)
+				firstLine = highlightStyled(firstLine, terms, styles["selfBody"])
+			} else {
+				firstLine = highlightMatches(firstLine, terms)
 			}
 		}
 
@@ -985,7 +1556,9 @@ func (mp messages_page) renderMsg(msg message, idx int, sender string) (string,
 
 			// Apply consistent styling to continuation lines (unless reply highlighted)
 			if !hasReplyHighlight && msg.FromMe {
-				continuationLine = styles["selfBody"].Render(continuationLine)
+				continuationLine = highlightStyled(continuationLine, terms, styles["selfBody"])
+			} else {
+				continuationLine = highlightMatches(continuationLine, terms)
 			}
 
 			// Build complete continuation line
@@ -997,7 +1570,15 @@ func (mp messages_page) renderMsg(msg message, idx int, sender string) (string,
 				completeContLine = styles["replyHighlight"].Width(mp.container.app.width).Render(completeContLine + linePadding)
 			}
 
-			ret += "\n" + completeLine
+			ret += "\n" + completeContLine
+		}
+		if preview != "" {
+			for _, line := range strings.Split(preview, "\n") {
+				ret += "\n" + strings.Repeat(" ", fullPrefixLength) + line
+			}
+		}
+		if len(msg.Reactions) > 0 {
+			ret += "\n" + strings.Repeat(" ", fullPrefixLength) + reactionSummary(msg.Reactions)
 		}
 	}
 	return ret, false
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// testMessagesPage opens chatID of b in a messages page sized like a small
+// terminal, with no lua renders so the fallback one is used
+func testMessagesPage(t *testing.T, b Backend, chatID string) *messages_page {
+	t.Helper()
+	L := lua.NewState()
+	t.Cleanup(L.Close)
+	if err := L.DoString("renders = {}"); err != nil {
+		t.Fatal(err)
+	}
+	acc := &account{
+		backend:     b,
+		id_to_name:  make(map[string]string),
+		chat_states: make(map[string]string),
+		drafts:      make(map[string]string),
+		unsaved:     make(map[string]bool),
+		previews:    make(map[string]*imagePreview),
+	}
+	a := &app{accounts: []*account{acc}, current: acc, width: 80, height: 24, luaState: L}
+	pc := new_page_container(nil, a)
+	mp := new_messages_page(Chat{ID: chatID}, pc)
+	return &mp
+}
+
+// testMessages is n messages with ids prefix0 to prefix<n-1>, oldest first
+func testMessages(prefix string, n int) []message {
+	msgs := make([]message, n)
+	for i := range msgs {
+		msgs[i] = message{MsgID: fmt.Sprintf("%s%d", prefix, i), Body: fmt.Sprintf("message %d", i), From: "1@c.us"}
+	}
+	return msgs
+}
+
+// runCommands feeds mp what cmd returns, and then what the commands the
+// page queues return, until there are none left
+func runCommands(mp messages_page, cmd tea.Cmd) messages_page {
+	var page tea.Model = mp
+	pending := []tea.Cmd{cmd}
+	for len(pending) > 0 {
+		cmd := pending[0]
+		pending = pending[1:]
+		if msg := cmd(); msg != nil {
+			page, _ = page.Update(msg)
+		}
+		pc := page.(messages_page).container
+		pending = append(pending, pc.commands...)
+		pc.commands = nil
+	}
+	return page.(messages_page)
+}
+
+func TestPrependKeepsVisualRange(t *testing.T) {
+	mp := testMessagesPage(t, newMemoryBackend(), "1@c.us")
+	mp.messages = testMessages("new", 5)
+	mp.selectMessage(1)
+	mp.toggleVisual()
+	mp.selectMessage(3)
+
+	mp.prependMessages(testMessages("old", 4))
+
+	var ids []string
+	for _, idx := range mp.selection() {
+		ids = append(ids, mp.messages[idx].MsgID)
+	}
+	if want := []string{"new1", "new2", "new3"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("selection after prepend = %v, want %v", ids, want)
+	}
+}
+
+func TestJumpToOlderMessage(t *testing.T) {
+	const chatID = "5500000000001@c.us"
+	mb := newMemoryBackend()
+	for i := range 3 * messagesPageSize {
+		mb.receive(chatID, chatID, fmt.Sprintf("message %d", i))
+	}
+	target := mb.messages[chatID][10].MsgID
+
+	mp := testMessagesPage(t, mb, chatID)
+	mp.jumpToMsg = target
+	got := runCommands(*mp, getMessages(mb, chatID))
+	if got.jumpToMsg != "" {
+		t.Errorf("jumpToMsg = %q after loading, want it cleared", got.jumpToMsg)
+	}
+	if got.selectedMsg < 0 || got.messages[got.selectedMsg].MsgID != target {
+		t.Errorf("selected message %d, want %s selected", got.selectedMsg, target)
+	}
+}
+
+func TestLoadForOtherChatIsDropped(t *testing.T) {
+	const alice, bob = "5500000000001@c.us", "5500000000002@c.us"
+	mb := newMemoryBackend()
+	mp := testMessagesPage(t, mb, bob)
+
+	// alice's load was still in flight when her chat was left for bob's
+	got := runCommands(*mp, getMessages(mb, alice))
+	got = runCommands(got, getMessages(mb, bob))
+
+	want := mb.messages[bob]
+	if len(got.messages) != len(want) {
+		t.Fatalf("bob's chat has %d messages, want his %d", len(got.messages), len(want))
+	}
+	for i, m := range got.messages {
+		if m.MsgID != want[i].MsgID {
+			t.Errorf("messages[%d] = %s (%q), want bob's %s", i, m.MsgID, m.Body, want[i].MsgID)
+		}
+	}
+}
+
+func TestChangeKeepsLoadedHistory(t *testing.T) {
+	const chatID = "5500000000001@c.us"
+	tests := []struct {
+		name    string
+		change  func(mp *messages_page, idx int)
+		changed func(m message) bool
+	}{
+		{
+			"react",
+			func(mp *messages_page, idx int) {
+				mp.selectMessage(idx)
+				mp.reactSelected("👍")
+			},
+			func(m message) bool { return m.myReaction() == "👍" },
+		},
+		{
+			"edit",
+			func(mp *messages_page, idx int) {
+				mp.editingMsg = mp.messages[idx].MsgID
+				mp.submitEdit("fixed")
+			},
+			func(m message) bool { return m.Body == "fixed" && m.IsEdited },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mb := newMemoryBackend()
+			for i := range 2 * messagesPageSize {
+				mb.receive(chatID, chatID, fmt.Sprintf("message %d", i))
+			}
+			mp := testMessagesPage(t, mb, chatID)
+			mp.messages = append([]message(nil), mb.messages[chatID]...)
+			loaded := len(mp.messages)
+
+			tt.change(mp, 3)
+			got := runCommands(*mp, func() tea.Msg { return nil })
+			if len(got.messages) != loaded {
+				t.Errorf("%d messages after the %s, want the %d loaded", len(got.messages), tt.name, loaded)
+			}
+			if !tt.changed(got.messages[3]) {
+				t.Errorf("message after the %s = %+v, want it changed", tt.name, got.messages[3])
+			}
+
+			// the backend doesn't know this one, so the change is undone
+			got.messages[4].MsgID = "gone"
+			orig := got.messages[4]
+			tt.change(&got, 4)
+			got = runCommands(got, func() tea.Msg { return nil })
+			if !reflect.DeepEqual(got.messages[4], orig) {
+				t.Errorf("message after a failed %s = %+v, want %+v back", tt.name, got.messages[4], orig)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/ansi"
+	zone "github.com/lrstanley/bubblezone"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Mouse events go through the same keybind tables as keys, under names like
+// "left press", "wheel up" or "ctrl+left press". The lines of the chat list
+// and of the messages are marked with zones so a click can be told apart
+// from the bars and the other pane
+
+// lineZone is the zone of a line of a list, row counts from the top of the
+// whole list
+func lineZone(list string, row int) string {
+	return list + "." + strconv.Itoa(row)
+}
+
+// markLines marks the rows of a list shown from start on
+func markLines(list string, lines []string, start int) []string {
+	marked := make([]string, len(lines))
+	for i, line := range lines {
+		marked[i] = zone.Mark(lineZone(list, start+i), line)
+	}
+	return marked
+}
+
+// rowAt finds which of the rows from start to end is under the mouse and
+// the column it was on, -1 when it is on none of them. Only the height of
+// the zone counts, lines are as wide as their text
+func rowAt(list string, start, end int, msg tea.MouseMsg) (int, int) {
+	for row := start; row < end; row++ {
+		z := zone.Get(lineZone(list, row))
+		if z.IsZero() || msg.Y < z.StartY || msg.Y > z.EndY {
+			continue
+		}
+		return row, msg.X - z.StartX
+	}
+	return -1, -1
+}
+
+// labelAt reports whether col of line falls on a label like [MEDIA] or
+// [REPLY: ...], open being its start
+func labelAt(line string, col int, open string) bool {
+	text := ansi.Strip(line)
+	from := 0
+	for {
+		i := strings.Index(text[from:], open)
+		if i == -1 {
+			return false
+		}
+		i += from
+		j := strings.Index(text[i:], "]")
+		if j == -1 {
+			j = len(text) - i - 1
+		}
+		start := ansi.StringWidth(text[:i])
+		end := start + ansi.StringWidth(text[i:i+j+1])
+		if col >= start && col < end {
+			return true
+		}
+		from = i + j + 1
+	}
+}
+
+// registerMouseFuncs exposes the mouse event being handled to lua,
+// mouse_event() is nil for keys
+func registerMouseFuncs(L *lua.LState, pc *pageContainer) {
+	L.SetGlobal("mouse_event", L.NewFunction(func(L *lua.LState) int {
+		if pc.mouse == nil {
+			L.Push(lua.LNil)
+			return 1
+		}
+		m := pc.mouse
+		tbl := L.NewTable()
+		tbl.RawSetString("x", lua.LNumber(m.X))
+		tbl.RawSetString("y", lua.LNumber(m.Y))
+		tbl.RawSetString("name", lua.LString(m.String()))
+		tbl.RawSetString("wheel", lua.LBool(tea.MouseEvent(*m).IsWheel()))
+		L.Push(tbl)
+		return 1
+	}))
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	tea "github.com/charmbracelet/bubbletea"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// maxHistory is how many pages the back history keeps
+const maxHistory = 50
+
+// navRequest is a push_page or pop_page call from lua, applied once the page
+// that ran the keybind is done updating
+type navRequest struct {
+	pop  bool
+	page string
+	arg  string
+}
+
+// push shows next on top of from, popping brings from back as it was
+func (pc *pageContainer) push(from, next tea.Model) tea.Model {
+	pc.stack = append(pc.stack, pc.current(from))
+	if len(pc.stack) > maxHistory {
+		pc.stack = pc.stack[1:]
+	}
+	return next
+}
+
+// pop goes back to the page under the current one, or to fallback() when
+// there is none. The page missed every event while it was covered, so it is
+// refreshed
+func (pc *pageContainer) pop(fallback func() tea.Model) tea.Model {
+	if len(pc.stack) == 0 {
+		return fallback()
+	}
+	p := pc.stack[len(pc.stack)-1]
+	pc.stack = pc.stack[:len(pc.stack)-1]
+	pc.refresh(p)
+	return p
+}
+
+// replace swaps the current page for next, the history stays
+func (pc *pageContainer) replace(next tea.Model) tea.Model {
+	return next
+}
+
+// reset drops the history, for when it no longer applies, like after
+// switching accounts
+func (pc *pageContainer) reset(next tea.Model) tea.Model {
+	pc.stack = nil
+	pc.split = nil
+	return next
+}
+
+func (pc *pageContainer) canPop() bool {
+	return len(pc.stack) > 0
+}
+
+// current is the page to save in the history, in the split layout the panes
+// are part of the split page
+func (pc *pageContainer) current(from tea.Model) tea.Model {
+	if pc.split == nil || pc.app.layout.Mode != "split" {
+		return from
+	}
+	sp := *pc.split
+	switch p := from.(type) {
+	case chats_page:
+		sp.chats = p
+	case messages_page:
+		sp.messages = &p
+	default:
+		return from
+	}
+	return sp
+}
+
+func (pc *pageContainer) refresh(p tea.Model) {
+	b := pc.app.current.backend
+	switch p := p.(type) {
+	case chats_page:
+		pc.commands = append(pc.commands, getChats(b))
+	case messages_page:
+		pc.commands = append(pc.commands, getMessages(b, p.from_chat.ID))
+	case split_page:
+		pc.commands = append(pc.commands, getChats(b))
+		if p.messages != nil {
+			pc.commands = append(pc.commands, getMessages(b, p.messages.from_chat.ID))
+		}
+	}
+}
+
+// chatsFallback is where going back ends up when there is no history
+func (pc *pageContainer) chatsFallback() tea.Model {
+	pc.commands = append(pc.commands, getChats(pc.app.current.backend))
+	return new_chats_page(pc)
+}
+
+// navigate applies the lua navigation request, if any, on top of p
+func (pc *pageContainer) navigate(p tea.Model) tea.Model {
+	req := pc.request
+	pc.request = nil
+	if req == nil {
+		return p
+	}
+	if req.pop {
+		return pc.pop(func() tea.Model { return p })
+	}
+
+	b := pc.app.current.backend
+	switch req.page {
+	case "chats":
+		pc.commands = append(pc.commands, getChats(b))
+		return pc.push(p, new_chats_page(pc))
+	case "messages":
+		chat := Chat{ID: req.arg, Name: pc.app.current.id_to_name[req.arg]}
+		pc.commands = append(pc.commands, getMessages(b, chat.ID))
+		return pc.push(p, new_messages_page(chat, pc))
+	case "search":
+		return pc.push(p, new_search_page(pc))
+	case "accounts":
+		return pc.push(p, new_accounts_page(pc))
+	}
+	return p
+}
+
+// registerNavigationFuncs exposes the page history to lua, push_page takes
+// "chats", "search", "accounts" or "messages" with a chat id
+func registerNavigationFuncs(L *lua.LState, pc *pageContainer) {
+	L.SetGlobal("push_page", L.NewFunction(func(L *lua.LState) int {
+		name := L.CheckString(1)
+		switch name {
+		case "chats", "search", "accounts":
+		case "messages":
+			L.CheckString(2)
+		default:
+			L.ArgError(1, "unknown page "+name)
+		}
+		pc.request = &navRequest{page: name, arg: L.OptString(2, "")}
+		return 0
+	}))
+	L.SetGlobal("pop_page", L.NewFunction(func(L *lua.LState) int {
+		pc.request = &navRequest{pop: true}
+		return 0
+	}))
+}
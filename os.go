@@ -76,6 +76,34 @@ func openURL(url string) {
 	_ = exec.Command(cmd, args...).Start()
 }
 
+// openFile opens path with command, which may carry flags, or with the
+// system opener when command is empty
+func openFile(command, path string) error {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		openURL(path)
+		return nil
+	}
+	return exec.Command(args[0], append(args[1:], path)...).Start()
+}
+
+// editorCommand opens path in $VISUAL or $EDITOR, which may carry flags,
+// falling back to vi (notepad on windows)
+func editorCommand(path string) *exec.Cmd {
+	editor := strings.Fields(os.Getenv("VISUAL"))
+	if len(editor) == 0 {
+		editor = strings.Fields(os.Getenv("EDITOR"))
+	}
+	if len(editor) == 0 {
+		if runtime.GOOS == "windows" {
+			editor = []string{"notepad"}
+		} else {
+			editor = []string{"vi"}
+		}
+	}
+	return exec.Command(editor[0], append(editor[1:], path)...)
+}
+
 func getClipboardMediaFile() (string, error) {
 	// Try platform-specific clipboard image extraction
 	switch runtime.GOOS {
@@ -202,14 +230,43 @@ func ensureLuaPath() (string, error) {
 		}
 	}
 
+	configLuaPath := filepath.Join(luaDir, "config.lua")
+	if _, err := os.Stat(configLuaPath); os.IsNotExist(err) {
+		if err := os.WriteFile(configLuaPath, []byte(defaultConfigLua), 0644); err != nil {
+			return "", fmt.Errorf("failed to write default config.lua: %w", err)
+		}
+	}
+
 	return luaDir, nil
 }
 
+var defaultConfigLua = `
+-- startup settings, WHATSCLI_* env vars and command-line flags override these
+config = {
+	backend = "whatshttp",                  -- or "memory" for the in-memory stub
+	backend_url = "http://localhost:3000",
+	client_id = "1",
+	webhook_addr = ":4000",
+}
+`
+
 
 var defaultInitLua = `
 message_keybinds = {
-	["up"] = function() scroll_up() end,
-	["down"] = function() scroll_down() end,
+	["up"] = function()
+		if not input_up() then
+			scroll_up()
+		end
+	end,
+	["down"] = function()
+		if not input_down() then
+			scroll_down()
+		end
+	end,
+	["ctrl+p"] = function() history_prev() end,
+	["ctrl+n"] = function() history_next() end,
+	["ctrl+x"] = function() edit_input_external() end,
+	["ctrl+o"] = function() attach_files() end,
 	["ctrl+c"] = function() quit() end,
 	["esc"] = function() escape() end,
 	["enter"] = function()
@@ -222,10 +279,82 @@ message_keybinds = {
 	["backspace"] = function() backspace_input() end,
 	["r"] = function() toggle_reply() end,
 	["m"] = function() open_media() end,
+	["s"] = function() save_media() end,
+	["o"] = function() save_media(true) end,
 	["f"] = function() forward_selected() end,
+	[" "] = function() mark_selected() end,
+	["V"] = function() visual_mode() end,
+	["y"] = function() copy_selected() end,
+	["E"] = function() export_selected() end,
+	["e"] = function() edit_selected() end,
 	["d"] = function() delete_selected() end,
+	["+"] = function() react_selected() end,
+	["/"] = function() start_search() end,
+	["n"] = function() search_next() end,
+	["N"] = function() search_prev() end,
+	["left press"] = function() click() end,
+	["wheel up"] = function() scroll_up() end,
+	["wheel down"] = function() scroll_down() end,
 }
 
+message_search_keybinds = {
+	["enter"] = function() submit_input() end,
+	["backspace"] = function() backspace_input() end,
+	["esc"] = function() escape() end,
+	["ctrl+c"] = function() quit() end,
+}
+
+-- used while the reaction picker is open, react_selected() without an emoji opens it
+message_react_keybinds = {
+	["left"] = function() react_prev() end,
+	["right"] = function() react_next() end,
+	["h"] = function() react_prev() end,
+	["l"] = function() react_next() end,
+	["1"] = function() react_pick(1) end,
+	["2"] = function() react_pick(2) end,
+	["3"] = function() react_pick(3) end,
+	["4"] = function() react_pick(4) end,
+	["5"] = function() react_pick(5) end,
+	["6"] = function() react_pick(6) end,
+	["enter"] = function() react_pick() end,
+	["esc"] = function() escape() end,
+	["ctrl+c"] = function() quit() end,
+}
+
+-- used while the delete dialog is open, delete_selected() opens it
+message_delete_keybinds = {
+	["up"] = function() delete_prev() end,
+	["down"] = function() delete_next() end,
+	["k"] = function() delete_prev() end,
+	["j"] = function() delete_next() end,
+	["m"] = function() delete_confirm("me") end,
+	["e"] = function() delete_confirm("everyone") end,
+	["enter"] = function() delete_confirm() end,
+	["esc"] = function() escape() end,
+	["ctrl+c"] = function() quit() end,
+}
+
+-- used while the file picker of attach_files() is open
+message_attach_keybinds = {
+	["up"] = function() attach_prev() end,
+	["down"] = function() attach_next() end,
+	["k"] = function() attach_prev() end,
+	["j"] = function() attach_next() end,
+	[" "] = function() attach_mark() end,
+	["enter"] = function() attach_open() end,
+	["right"] = function() attach_open() end,
+	["l"] = function() attach_open() end,
+	["backspace"] = function() attach_up() end,
+	["left"] = function() attach_up() end,
+	["h"] = function() attach_up() end,
+	["tab"] = function() attach_filter() end,
+	["t"] = function() attach_filter() end,
+	["esc"] = function() escape() end,
+	["ctrl+c"] = function() quit() end,
+}
+
+quick_reactions = { "👍", "❤️", "😂", "😮", "😢", "🙏" }
+
 chat_keybinds = {
 	["up"] = function() chat_scroll_up() end,
 	["down"] = function() chat_scroll_down() end,
@@ -234,6 +363,42 @@ chat_keybinds = {
 	["ctrl+c"] = function() chat_escape() end,
 	["esc"] = function() chat_escape() end,
 	["enter"] = function() chat_select() end,
+	[" "] = function() chat_mark() end,
+	["a"] = function() open_accounts() end,
+	["/"] = function() open_search() end,
+	["left press"] = function() chat_click() end,
+	["wheel up"] = function() chat_scroll_up() end,
+	["wheel down"] = function() chat_scroll_down() end,
+}
+
+account_keybinds = {
+	["up"] = function() account_scroll_up() end,
+	["down"] = function() account_scroll_down() end,
+	["k"] = function() account_scroll_up() end,
+	["j"] = function() account_scroll_down() end,
+	["esc"] = function() account_escape() end,
+	["enter"] = function() account_select() end,
+}
+
+search_keybinds = {
+	["up"] = function() search_scroll_up() end,
+	["down"] = function() search_scroll_down() end,
+	["ctrl+k"] = function() search_scroll_up() end,
+	["ctrl+j"] = function() search_scroll_down() end,
+	["backspace"] = function() search_backspace() end,
+	["esc"] = function() search_escape() end,
+	["enter"] = function() search_select() end,
+}
+
+layout = {
+	mode = "single", -- "split" shows the chat list and the open chat side by side
+	chats_width = 0.3, -- columns, or a fraction of the terminal
+}
+
+split_keybinds = {
+	["tab"] = function() focus_next_pane() end,
+	["ctrl+left"] = function() resize_pane(-2) end,
+	["ctrl+right"] = function() resize_pane(2) end,
 }
 
 renders = {
@@ -249,9 +414,15 @@ renders = {
 		local iso = tostring(msg["timestamp"] or "")
 		local y, m, d, h, min = iso:match("(%d+)%-(%d+)%-(%d+)T(%d+):(%d+)")
 		local timestamp = string.format("%s:%s - %s/%s", h or "??", min or "??", m or "??", d or "??")
+		if msg["isEdited"] then
+			timestamp = timestamp .. " (edited)"
+		end
 
 		-- Prepare message body
 		local body = tostring(msg["body"] or "")
+		if info["is_match"] then
+			body = highlight_search(body)
+		end
 		if msg["hasMedia"] then
 			body = fg(styles.hyperlink.fg) .. bg(styles.hyperlink.bg) .. "[MEDIA]" .. reset() .. "\n" .. body
 		elseif msg["type"] == "revoked" then
@@ -261,6 +432,9 @@ renders = {
 		elseif msg["type"] == "ptt" then
 			body = fg(styles.hyperlink.fg) .. bg(styles.hyperlink.bg) .. "[VOICE AUDIO]" .. reset()
 		end
+		if info["quoted"] then
+			body = fg(styles.replyHighlight.fg) .. bg(styles.replyHighlight.bg) .. "[REPLY: " .. info["quoted"] .. "]" .. reset() .. "\n" .. body
+		end
 
 		local allowed_types = {
 			chat = true,
@@ -317,8 +491,16 @@ renders = {
 		end
 		table.insert(bubble, tail)
 
+		-- Reactions
+		if info["reactions"] then
+			table.insert(bubble, info["reactions"])
+		end
+
 		-- Name + timestamp
 		local name = tostring(info["name"] or "")
+		if info["is_marked"] then
+			name = "[x] " .. name
+		end
 		if name ~= "" then
 			local nt = name .. "  " .. timestamp
 			if fromMe then
@@ -341,19 +523,157 @@ renders = {
 			end
 		end
 
+		-- Image preview, under the bubble
+		if info["preview"] then
+			local pad = fromMe and math.max(0, termWidth - (tonumber(info["preview_width"]) or 0)) or 0
+			for line in info["preview"]:gmatch("[^\n]+") do
+				table.insert(bubble, string.rep(" ", pad) .. line)
+			end
+		end
+
 		return table.concat(bubble, "\n")
 	end,
 
 	["chat"] = function(tbl)
+		local name = tbl['chat']['name']
+		if tbl['info']['state'] == "composing" then
+			name = name .. " (typing...)"
+		elseif tbl['info']['state'] == "recording" then
+			name = name .. " (recording...)"
+		end
+		if tbl['info']['draft'] ~= "" then
+			name = "draft: " .. name
+		end
+		if tbl['info']['forwarding'] then
+			name = (tbl['info']['is_marked'] and "[x] " or "[ ] ") .. name
+		end
 		if tbl['info']['is_selected'] then
-			return fg(styles.selectedStyle.fg) .. "> " .. tbl['chat']['name'] .. reset() .. "\n"
+			return fg(styles.selectedStyle.fg) .. "> " .. name .. reset() .. "\n"
 		end
-		return fg(styles.unselectedStyle.fg) .. "  " .. tbl['chat']['name'] .. reset() .. "\n"
+		return fg(styles.unselectedStyle.fg) .. "  " .. name .. reset() .. "\n"
 	end
 }
 `
 
 
+// defaultFallbackLua runs after init.lua and fills in the tables that were
+// added after the user's init.lua was written
+var defaultFallbackLua = `
+account_keybinds = account_keybinds or {
+	["up"] = function() account_scroll_up() end,
+	["down"] = function() account_scroll_down() end,
+	["k"] = function() account_scroll_up() end,
+	["j"] = function() account_scroll_down() end,
+	["esc"] = function() account_escape() end,
+	["enter"] = function() account_select() end,
+}
+message_search_keybinds = message_search_keybinds or {
+	["enter"] = function() submit_input() end,
+	["backspace"] = function() backspace_input() end,
+	["esc"] = function() escape() end,
+	["ctrl+c"] = function() quit() end,
+}
+message_delete_keybinds = message_delete_keybinds or {
+	["up"] = function() delete_prev() end,
+	["down"] = function() delete_next() end,
+	["k"] = function() delete_prev() end,
+	["j"] = function() delete_next() end,
+	["m"] = function() delete_confirm("me") end,
+	["e"] = function() delete_confirm("everyone") end,
+	["enter"] = function() delete_confirm() end,
+	["esc"] = function() escape() end,
+	["ctrl+c"] = function() quit() end,
+}
+message_attach_keybinds = message_attach_keybinds or {
+	["up"] = function() attach_prev() end,
+	["down"] = function() attach_next() end,
+	["k"] = function() attach_prev() end,
+	["j"] = function() attach_next() end,
+	[" "] = function() attach_mark() end,
+	["enter"] = function() attach_open() end,
+	["right"] = function() attach_open() end,
+	["l"] = function() attach_open() end,
+	["backspace"] = function() attach_up() end,
+	["left"] = function() attach_up() end,
+	["h"] = function() attach_up() end,
+	["tab"] = function() attach_filter() end,
+	["t"] = function() attach_filter() end,
+	["esc"] = function() escape() end,
+	["ctrl+c"] = function() quit() end,
+}
+message_react_keybinds = message_react_keybinds or {
+	["left"] = function() react_prev() end,
+	["right"] = function() react_next() end,
+	["h"] = function() react_prev() end,
+	["l"] = function() react_next() end,
+	["1"] = function() react_pick(1) end,
+	["2"] = function() react_pick(2) end,
+	["3"] = function() react_pick(3) end,
+	["4"] = function() react_pick(4) end,
+	["5"] = function() react_pick(5) end,
+	["6"] = function() react_pick(6) end,
+	["enter"] = function() react_pick() end,
+	["esc"] = function() escape() end,
+	["ctrl+c"] = function() quit() end,
+}
+
+split_keybinds = split_keybinds or {
+	["tab"] = function() focus_next_pane() end,
+	["ctrl+left"] = function() resize_pane(-2) end,
+	["ctrl+right"] = function() resize_pane(2) end,
+}
+
+search_keybinds = search_keybinds or {
+	["up"] = function() search_scroll_up() end,
+	["down"] = function() search_scroll_down() end,
+	["ctrl+k"] = function() search_scroll_up() end,
+	["ctrl+j"] = function() search_scroll_down() end,
+	["backspace"] = function() search_backspace() end,
+	["esc"] = function() search_escape() end,
+	["enter"] = function() search_select() end,
+}
+
+-- binds added after the chat and message keybinds of older configs
+local function default_binds(binds, defaults)
+	if binds == nil then
+		return
+	end
+	for key, f in pairs(defaults) do
+		if binds[key] == nil then
+			binds[key] = f
+		end
+	end
+end
+default_binds(chat_keybinds, {
+	["left press"] = function() chat_click() end,
+	["wheel up"] = function() chat_scroll_up() end,
+	["wheel down"] = function() chat_scroll_down() end,
+	[" "] = function() chat_mark() end,
+	["a"] = function() open_accounts() end,
+	["/"] = function() open_search() end,
+})
+default_binds(message_keybinds, {
+	["left press"] = function() click() end,
+	["wheel up"] = function() scroll_up() end,
+	["wheel down"] = function() scroll_down() end,
+	["ctrl+p"] = function() history_prev() end,
+	["ctrl+n"] = function() history_next() end,
+	["ctrl+x"] = function() edit_input_external() end,
+	["ctrl+o"] = function() attach_files() end,
+	["+"] = function() react_selected() end,
+	["e"] = function() edit_selected() end,
+	[" "] = function() mark_selected() end,
+	["V"] = function() visual_mode() end,
+	["y"] = function() copy_selected() end,
+	["E"] = function() export_selected() end,
+	["s"] = function() save_media() end,
+	["o"] = function() save_media(true) end,
+	["/"] = function() start_search() end,
+	["n"] = function() search_next() end,
+	["N"] = function() search_prev() end,
+})
+`
+
 var defaultColorsLua = `
 function strip_ansi(str)
   -- Remove ANSI escape sequences
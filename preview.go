@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Images are previewed inside the message list with the kitty graphics
+// protocol, sixel, or half blocks colored with truecolor when the terminal
+// supports neither. The decoded thumbnail is kept per message id and drawn
+// again for each size it is shown at.
+//
+// The image data never goes through lua: kitty gets each image once, written
+// straight to the terminal, and the messages only hold its placeholder
+// cells. Sixel images are a short marker until View swaps them in
+
+const (
+	previewMaxCols = 32
+	previewMaxRows = 12
+	thumbMaxPixels = 320 // longest side of the kept thumbnail
+	cellPixelsW    = 10  // size of a cell assumed for sixel
+	cellPixelsH    = 20
+	previewRecent  = 10 // latest image messages whose previews are loaded
+	previewAround  = 8  // messages around the selected one whose previews are loaded
+)
+
+// previewFetches caps how many previews are fetched at the same time
+var previewFetches = make(chan struct{}, 3)
+
+// terminalOutput is the output of the program, locked so graphics can be
+// written to it between the frames of the renderer
+type terminalOutput struct {
+	*os.File
+	mu sync.Mutex
+}
+
+func (t *terminalOutput) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.File.Write(p)
+}
+
+var terminal = &terminalOutput{File: os.Stdout}
+
+// imagePreview is the thumbnail of an image message, err is set when it
+// could not be loaded and drawn is its rendering for each width. kittyID is
+// the id the image was sent to kitty with, 0 when it was not
+type imagePreview struct {
+	thumb   image.Image
+	err     error
+	kittyID uint32
+	drawn   map[int]renderedPreview
+}
+
+type renderedPreview struct {
+	text  string
+	width int
+	sixel string // the image the marker in text stands for
+}
+
+// previewLoadedMsg brings the thumbnail of a message
+type previewLoadedMsg struct {
+	acc     *account
+	msgID   string
+	thumb   image.Image
+	kittyID uint32
+	err     error
+}
+
+// previewMode is config.image_previews with "auto" resolved from the
+// environment of the terminal
+func previewMode(cfg config) string {
+	if cfg.ImagePreviews != "" && cfg.ImagePreviews != "auto" {
+		return cfg.ImagePreviews
+	}
+	term := os.Getenv("TERM")
+	switch {
+	case os.Getenv("TMUX") != "" || strings.HasPrefix(term, "screen"):
+		// the graphics escapes would need passthrough
+		return "blocks"
+	case os.Getenv("KITTY_WINDOW_ID") != "" || term == "xterm-kitty" || term == "xterm-ghostty":
+		return "kitty"
+	case os.Getenv("TERM_PROGRAM") == "WezTerm" || strings.HasPrefix(term, "foot") || strings.Contains(term, "mlterm") || strings.Contains(term, "sixel"):
+		return "sixel"
+	}
+	return "blocks"
+}
+
+// isPreviewable reports whether a message is an image a preview is drawn for
+func isPreviewable(msg message) bool {
+	return msg.HasMedia && (msg.Type == "image" || msg.Type == "sticker")
+}
+
+// loadPreview fetches the media of a message and shrinks it to a thumbnail,
+// which is sent to kitty right away in that mode
+func loadPreview(acc *account, msgID, mode string) tea.Cmd {
+	return func() tea.Msg {
+		previewFetches <- struct{}{}
+		defer func() { <-previewFetches }()
+
+		media, err := acc.backend.Media(msgID)
+		if err != nil {
+			return previewLoadedMsg{acc: acc, msgID: msgID, err: err}
+		}
+		defer media.Close()
+		img, _, err := image.Decode(media)
+		if err != nil {
+			return previewLoadedMsg{acc: acc, msgID: msgID, err: err}
+		}
+		msg := previewLoadedMsg{acc: acc, msgID: msgID, thumb: scaleImage(img, thumbMaxPixels, thumbMaxPixels)}
+		if mode == "kitty" {
+			msg.kittyID = kittyTransmit(msg.thumb, msgID)
+		}
+		return msg
+	}
+}
+
+// loadPreviews starts loading the thumbnails of the latest image messages
+// and the ones around the selected message, skipping those already loaded
+func (mp *messages_page) loadPreviews() {
+	a := mp.container.app
+	mode := previewMode(a.config)
+	if mode == "off" {
+		return
+	}
+	acc := a.current
+	load := func(msg message) {
+		if !isPreviewable(msg) || msg.MsgID == "" {
+			return
+		}
+		if _, ok := acc.previews[msg.MsgID]; ok {
+			return
+		}
+		acc.previews[msg.MsgID] = &imagePreview{}
+		mp.container.commands = append(mp.container.commands, loadPreview(acc, msg.MsgID, mode))
+	}
+
+	recent := 0
+	for i := len(mp.messages) - 1; i >= 0 && recent < previewRecent; i-- {
+		if isPreviewable(mp.messages[i]) {
+			load(mp.messages[i])
+			recent++
+		}
+	}
+	if !mp.inInput && mp.selectedMsg >= 0 {
+		for i := max(0, mp.selectedMsg-previewAround); i < min(len(mp.messages), mp.selectedMsg+previewAround+1); i++ {
+			load(mp.messages[i])
+		}
+	}
+}
+
+// previewLoaded keeps a thumbnail that arrived, the failed ones are kept too
+// so they are not fetched again
+func (a *app) previewLoaded(msg previewLoadedMsg) {
+	msg.acc.previews[msg.msgID] = &imagePreview{thumb: msg.thumb, kittyID: msg.kittyID, err: msg.err}
+}
+
+// preview is the drawing of the thumbnail of a message and how many cells
+// wide it is, "" while it loads or when there is none
+func (mp messages_page) preview(msg message) (string, int) {
+	a := mp.container.app
+	p, ok := a.current.previews[msg.MsgID]
+	if !ok || p.thumb == nil {
+		return "", 0
+	}
+	maxCols := min(previewMaxCols, a.width-4)
+	if maxCols < 2 {
+		return "", 0
+	}
+	if r, ok := p.drawn[maxCols]; ok {
+		return r.text, r.width
+	}
+	cols, rows := previewSize(p.thumb.Bounds(), maxCols, previewMaxRows)
+	r := renderedPreview{width: cols}
+	switch mode := previewMode(a.config); {
+	case mode == "kitty" && p.kittyID != 0:
+		r.text = kittyPreview(p.kittyID, cols, rows)
+	case mode == "sixel":
+		r.text = sixelPreview(msg.MsgID, maxCols, cols, rows)
+		r.sixel = encodeSixel(resizeImage(p.thumb, cols*cellPixelsW, rows*cellPixelsH))
+	default:
+		r.text = blockPreview(p.thumb, cols, rows)
+	}
+	if p.drawn == nil {
+		p.drawn = make(map[int]renderedPreview)
+	}
+	p.drawn[maxCols] = r
+	return r.text, cols
+}
+
+// previewSize fits an image in at most maxCols by maxRows cells, a cell
+// being twice as tall as it is wide
+func previewSize(b image.Rectangle, maxCols, maxRows int) (int, int) {
+	w, h := max(1, b.Dx()), max(1, b.Dy())
+	cols := maxCols
+	rows := max(1, (cols*h+w)/(2*w))
+	if rows > maxRows {
+		rows = maxRows
+		cols = max(1, min(maxCols, 2*rows*w/h))
+	}
+	return cols, rows
+}
+
+// scaleImage shrinks img to fit w by h pixels averaging the pixels each
+// one covers, it is never enlarged
+func scaleImage(img image.Image, w, h int) image.Image {
+	b := img.Bounds()
+	scale := min(float64(w)/float64(b.Dx()), float64(h)/float64(b.Dy()), 1)
+	return resizeImage(img, max(1, int(float64(b.Dx())*scale)), max(1, int(float64(b.Dy())*scale)))
+}
+
+// resizeImage scales img to exactly w by h pixels
+func resizeImage(img image.Image, w, h int) *image.RGBA {
+	b := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0 := b.Min.Y + y*b.Dy()/h
+		y1 := max(y0+1, b.Min.Y+(y+1)*b.Dy()/h)
+		for x := 0; x < w; x++ {
+			x0 := b.Min.X + x*b.Dx()/w
+			x1 := max(x0+1, b.Min.X+(x+1)*b.Dx()/w)
+			var r, g, bl, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+pr, g+pg, bl+pb, a+pa, n+1
+				}
+			}
+			out.SetRGBA(x, y, color.RGBA{uint8(r / n >> 8), uint8(g / n >> 8), uint8(bl / n >> 8), uint8(a / n >> 8)})
+		}
+	}
+	return out
+}
+
+// blockPreview draws two pixels per cell with "▀", the top one as the
+// foreground and the bottom one as the background
+func blockPreview(img image.Image, cols, rows int) string {
+	px := resizeImage(img, cols, rows*2)
+	var b strings.Builder
+	for y := 0; y < rows; y++ {
+		if y > 0 {
+			b.WriteString("\n")
+		}
+		for x := 0; x < cols; x++ {
+			top, bottom := px.RGBAAt(x, 2*y), px.RGBAAt(x, 2*y+1)
+			fmt.Fprintf(&b, "\x1b[38;2;%d;%d;%d;48;2;%d;%d;%dm▀", top.R, top.G, top.B, bottom.R, bottom.G, bottom.B)
+		}
+		b.WriteString("\x1b[0m")
+	}
+	return b.String()
+}
+
+// kittyDiacritics mark the row and column of an image placeholder cell
+var kittyDiacritics = []rune{
+	0x0305, 0x030D, 0x030E, 0x0310, 0x0312, 0x033D, 0x033E, 0x033F,
+	0x0346, 0x034A, 0x034B, 0x034C, 0x0350, 0x0351, 0x0352, 0x0357,
+	0x035B, 0x0363, 0x0364, 0x0365, 0x0366, 0x0367, 0x0368, 0x0369,
+	0x036A, 0x036B, 0x036C, 0x036D, 0x036E, 0x036F, 0x0483, 0x0484,
+	0x0485, 0x0486, 0x0487, 0x0592, 0x0593, 0x0594, 0x0595, 0x0597,
+}
+
+// kittyTransmit sends a thumbnail to kitty, with an id derived from the
+// message id, and returns that id, 0 when it could not be encoded
+func kittyTransmit(img image.Image, msgID string) uint32 {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(msgID))
+	id := h.Sum32()&0xFFFFFF | 1
+
+	var b strings.Builder
+	data := base64.StdEncoding.EncodeToString(buf.Bytes())
+	for first := true; first || data != ""; first = false {
+		chunk := data[:min(4096, len(data))]
+		data = data[len(chunk):]
+		more := 0
+		if data != "" {
+			more = 1
+		}
+		if first {
+			fmt.Fprintf(&b, "\x1b_Ga=t,q=2,f=100,i=%d,m=%d;%s\x1b\\", id, more, chunk)
+		} else {
+			fmt.Fprintf(&b, "\x1b_Gm=%d;%s\x1b\\", more, chunk)
+		}
+	}
+	if _, err := terminal.Write([]byte(b.String())); err != nil {
+		return 0
+	}
+	return id
+}
+
+// kittyPreview places an image sent with kittyTransmit at cols by rows cells
+// and returns its unicode placeholders, which are plain cells so scrolling
+// and redrawing the list moves the image with the text. The placement is
+// numbered after its width so each size is placed once
+func kittyPreview(id uint32, cols, rows int) string {
+	fmt.Fprintf(terminal, "\x1b_Ga=p,U=1,q=2,i=%d,p=%d,c=%d,r=%d\x1b\\", id, cols, cols, rows)
+
+	var b strings.Builder
+	for y := 0; y < rows; y++ {
+		if y > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "\x1b[38;2;%d;%d;%dm\x1b[58;5;%dm", id>>16&0xFF, id>>8&0xFF, id&0xFF, cols)
+		for x := 0; x < cols; x++ {
+			b.WriteRune(0x10EEEE)
+			b.WriteRune(kittyDiacritics[y])
+			b.WriteRune(kittyDiacritics[x])
+		}
+		b.WriteString("\x1b[39;59m")
+	}
+	return b.String()
+}
+
+// sixelPreview leaves rows of blank cells with a marker on the last one,
+// which View swaps for the image drawn from there once every row above it
+// is on screen. The cursor is saved and restored around it so the lines
+// after it are not moved
+func sixelPreview(msgID string, maxCols, cols, rows int) string {
+	blank := strings.Repeat(" ", cols)
+	lines := make([]string, rows)
+	for i := range lines {
+		lines[i] = blank
+	}
+	up := ""
+	if rows > 1 {
+		up = fmt.Sprintf("\x1b[%dA", rows-1)
+	}
+	lines[rows-1] = fmt.Sprintf("\x1b7%s\x1b_whats-cli;%d;%s\x1b\\\x1b8%s", up, maxCols, msgID, blank)
+	return strings.Join(lines, "\n")
+}
+
+// encodeSixel draws img with a 6x6x6 color cube
+func encodeSixel(img *image.RGBA) string {
+	level := func(c uint8) int { return (int(c)*5 + 127) / 255 }
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "\x1bPq\"1;1;%d;%d", w, h)
+	for i := 0; i < 216; i++ {
+		fmt.Fprintf(&b, "#%d;2;%d;%d;%d", i, i/36*20, i/6%6*20, i%6*20)
+	}
+
+	idx := make([]int, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := img.RGBAAt(x, y)
+			idx[y*w+x] = level(c.R)*36 + level(c.G)*6 + level(c.B)
+		}
+	}
+
+	row := make([]byte, w)
+	for top := 0; top < h; top += 6 {
+		used := make(map[int]bool)
+		for y := top; y < min(top+6, h); y++ {
+			for x := 0; x < w; x++ {
+				used[idx[y*w+x]] = true
+			}
+		}
+		first := true
+		for c := 0; c < 216; c++ {
+			if !used[c] {
+				continue
+			}
+			for x := 0; x < w; x++ {
+				bits := 0
+				for dy := 0; dy < 6 && top+dy < h; dy++ {
+					if idx[(top+dy)*w+x] == c {
+						bits |= 1 << dy
+					}
+				}
+				row[x] = byte(63 + bits)
+			}
+			if !first {
+				b.WriteByte('$')
+			}
+			first = false
+			fmt.Fprintf(&b, "#%d", c)
+			writeSixelRow(&b, row)
+		}
+		b.WriteByte('-')
+	}
+	b.WriteString("\x1b\\")
+	return b.String()
+}
+
+// writeSixelRow writes a band of sixels, runs of the same one as "!count"
+func writeSixelRow(b *strings.Builder, row []byte) {
+	for i := 0; i < len(row); {
+		j := i
+		for j < len(row) && row[j] == row[i] {
+			j++
+		}
+		if j-i > 3 {
+			fmt.Fprintf(b, "!%d%c", j-i, row[i])
+		} else {
+			b.Write(row[i:j])
+		}
+		i = j
+	}
+}
+
+var sixelMarker = regexp.MustCompile(`\x1b7(?:\x1b\[(\d+)A)?\x1b_whats-cli;(\d+);([^\x1b]*)\x1b\\\x1b8`)
+
+// drawSixels swaps the sixel markers of lines for their images, dropping
+// those whose image would reach above the first of lines, or all of them
+// when a dialog is drawn over the messages. What is left of a dropped one
+// are its blank cells
+func (mp messages_page) drawSixels(lines []string, drop bool) []string {
+	previews := mp.container.app.current.previews
+	for i, line := range lines {
+		lines[i] = sixelMarker.ReplaceAllStringFunc(line, func(marker string) string {
+			m := sixelMarker.FindStringSubmatch(marker)
+			up, _ := strconv.Atoi(m[1])
+			maxCols, _ := strconv.Atoi(m[2])
+			p, ok := previews[m[3]]
+			if drop || up > i || !ok {
+				return ""
+			}
+			return strings.Replace(marker, "\x1b_whats-cli;"+m[2]+";"+m[3]+"\x1b\\", p.drawn[maxCols].sixel, 1)
+		})
+	}
+	return lines
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	_ "image/png"
+	"math"
+	"strings"
+)
+
+// qrToHalfBlocks renders the base64 QR image whatshttp returns as lines of
+// unicode half blocks, each line holding two rows of QR modules. Light
+// modules are drawn so the code reads correctly on dark terminals.
+func qrToHalfBlocks(data string) ([]string, error) {
+	// strip a "data:image/png;base64," prefix
+	if i := strings.Index(data, ","); i != -1 && strings.HasPrefix(data, "data:") {
+		data = data[i+1:]
+	}
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid qr data: %w", err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("invalid qr image: %w", err)
+	}
+
+	modules, err := qrModules(img)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2 modules of quiet zone around the code
+	const quiet = 2
+	size := len(modules) + 2*quiet
+	light := func(x, y int) bool {
+		x, y = x-quiet, y-quiet
+		if x < 0 || y < 0 || x >= len(modules) || y >= len(modules) {
+			return true
+		}
+		return !modules[y][x]
+	}
+
+	var lines []string
+	for y := 0; y < size; y += 2 {
+		var b strings.Builder
+		for x := 0; x < size; x++ {
+			top := light(x, y)
+			bottom := y+1 >= size || light(x, y+1)
+			switch {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+		lines = append(lines, b.String())
+	}
+	return lines, nil
+}
+
+// qrModules samples a (possibly scaled) QR image into its grid of modules,
+// true meaning dark. The module size comes from the top left finder
+// pattern, which is always 7 modules wide.
+func qrModules(img image.Image) ([][]bool, error) {
+	bounds := img.Bounds()
+	dark := func(x, y int) bool {
+		r, g, b, _ := img.At(x, y).RGBA()
+		return (r+g+b)/3 < 0x8000
+	}
+
+	x0, y0 := -1, -1
+	for y := bounds.Min.Y; y < bounds.Max.Y && y0 == -1; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if dark(x, y) {
+				x0, y0 = x, y
+				break
+			}
+		}
+	}
+	if x0 == -1 {
+		return nil, fmt.Errorf("qr image is blank")
+	}
+
+	run := 0
+	for x := x0; x < bounds.Max.X && dark(x, y0); x++ {
+		run++
+	}
+	x1 := x0
+	for x := bounds.Max.X - 1; x >= x0; x-- {
+		if dark(x, y0) {
+			x1 = x
+			break
+		}
+	}
+
+	moduleSize := float64(run) / 7
+	count := int(math.Round(float64(x1-x0+1) / moduleSize))
+	if moduleSize < 1 || count < 21 {
+		return nil, fmt.Errorf("could not find the qr code in the image")
+	}
+
+	modules := make([][]bool, count)
+	for my := range modules {
+		modules[my] = make([]bool, count)
+		for mx := range modules[my] {
+			px := x0 + int((float64(mx)+0.5)*moduleSize)
+			py := y0 + int((float64(my)+0.5)*moduleSize)
+			if px < bounds.Max.X && py < bounds.Max.Y {
+				modules[my][mx] = dark(px, py)
+			}
+		}
+	}
+	return modules, nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// defaultQuickReactions are offered by the picker when init.lua sets no
+// quick_reactions
+var defaultQuickReactions = []string{"👍", "❤️", "😂", "😮", "😢", "🙏"}
+
+// reaction is an emoji someone put on a message
+type reaction struct {
+	Emoji    string `json:"reaction"`
+	SenderID string `json:"senderId"`
+	FromMe   bool   `json:"fromMe"`
+}
+
+// react sets the reaction of a sender, an empty emoji removes it. Everyone
+// has at most one reaction per message
+func (msg *message) react(senderID, emoji string, fromMe bool) {
+	var kept []reaction
+	for _, r := range msg.Reactions {
+		if r.FromMe != fromMe || (!fromMe && r.SenderID != senderID) {
+			kept = append(kept, r)
+		}
+	}
+	msg.Reactions = kept
+	if emoji != "" {
+		msg.Reactions = append(msg.Reactions, reaction{Emoji: emoji, SenderID: senderID, FromMe: fromMe})
+	}
+}
+
+// myReaction is the emoji we reacted with, "" when none
+func (msg message) myReaction() string {
+	for _, r := range msg.Reactions {
+		if r.FromMe {
+			return r.Emoji
+		}
+	}
+	return ""
+}
+
+// reactionSummary counts the reactions of a message like "👍 2 ❤️", in the
+// order they were first used
+func reactionSummary(reactions []reaction) string {
+	var order []string
+	counts := make(map[string]int)
+	for _, r := range reactions {
+		if counts[r.Emoji] == 0 {
+			order = append(order, r.Emoji)
+		}
+		counts[r.Emoji]++
+	}
+	parts := make([]string, len(order))
+	for i, emoji := range order {
+		parts[i] = emoji
+		if counts[emoji] > 1 {
+			parts[i] += " " + strconv.Itoa(counts[emoji])
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// reactedMsg reports how sending our reaction went, prev is the emoji we
+// had on the message before
+type reactedMsg struct {
+	chatID string
+	msgID  string
+	prev   string
+	err    error
+}
+
+// reactMessage sends a reaction in the background, an empty emoji removes
+// ours. The message already shows it, so nothing is reloaded
+func reactMessage(b Backend, chatID, msgID, emoji, prev string) tea.Cmd {
+	return func() tea.Msg {
+		err := b.ReactMessage(msgID, emoji)
+		return reactedMsg{chatID: chatID, msgID: msgID, prev: prev, err: err}
+	}
+}
+
+// quickReactions is the quick_reactions table of init.lua
+func quickReactions(L *lua.LState) []string {
+	tbl, ok := L.GetGlobal("quick_reactions").(*lua.LTable)
+	if !ok {
+		return defaultQuickReactions
+	}
+	var emojis []string
+	tbl.ForEach(func(_, v lua.LValue) {
+		if s, ok := v.(lua.LString); ok && s != "" {
+			emojis = append(emojis, string(s))
+		}
+	})
+	if len(emojis) == 0 {
+		return defaultQuickReactions
+	}
+	return emojis
+}
+
+// reactSelected puts emoji on the selected message, reacting again with the
+// same emoji takes it back
+func (mp *messages_page) reactSelected(emoji string) {
+	mp.reactPicker = nil
+	if mp.inInput || mp.selectedMsg < 0 || mp.selectedMsg >= len(mp.messages) {
+		return
+	}
+	msg := &mp.messages[mp.selectedMsg]
+	prev := msg.myReaction()
+	if prev == emoji {
+		emoji = ""
+	}
+	msg.react("", emoji, true)
+	mp.keepSelectedVisible()
+	cmd := reactMessage(mp.container.app.current.backend, mp.from_chat.ID, msg.MsgID, emoji, prev)
+	mp.container.commands = append(mp.container.commands, cmd)
+}
+
+// reactDone puts our previous reaction back when sending the new one failed
+func (mp *messages_page) reactDone(msg reactedMsg) {
+	if msg.err == nil {
+		return
+	}
+	if _, idx := mp.findMessageByID(msg.msgID); idx != -1 {
+		mp.messages[idx].react("", msg.prev, true)
+		if idx == mp.selectedMsg {
+			mp.keepSelectedVisible()
+		}
+	}
+	mp.container.commands = append(mp.container.commands, flash(updateFlashMsg{msg: "Could not react: " + msg.err.Error(), count: 6}))
+}
+
+// keepSelectedVisible scrolls down when the selected message grew past the
+// bottom of the screen, like after a reaction adds a line under it
+func (mp *messages_page) keepSelectedVisible() {
+	mp.calculateMessageLines()
+	last := -1
+	for i, idx := range mp.lineMsg {
+		if idx == mp.selectedMsg {
+			last = i
+		}
+	}
+	if height := mp.messagesHeight(); last >= mp.scrollOffset+height {
+		mp.scrollOffset = last - height + 1
+	}
+}
+
+// pickerBar is the bottom bar while choosing a reaction
+func (mp messages_page) pickerBar() string {
+	var b strings.Builder
+	b.WriteString(" React:")
+	for i, emoji := range mp.reactPicker {
+		if i == mp.reactIdx {
+			b.WriteString(" [" + emoji + "]")
+		} else {
+			b.WriteString("  " + emoji + " ")
+		}
+	}
+	b.WriteString("  (←/→, enter, esc)")
+	return b.String()
+}
+
+// registerReactionFuncs exposes reacting and the reaction picker to lua
+func (mp *messages_page) registerReactionFuncs() {
+	L := mp.container.app.luaState
+
+	L.SetGlobal("react_selected", L.NewFunction(func(L *lua.LState) int {
+		if mp.inInput || mp.selectedMsg < 0 {
+			mp.container.app.luaReturn = "type"
+			return 0
+		}
+		if emoji := L.OptString(1, ""); emoji != "" {
+			mp.reactSelected(emoji)
+			return 0
+		}
+		// no emoji opens the picker on our current reaction
+		mp.reactPicker = quickReactions(L)
+		mp.reactIdx = 0
+		mine := mp.messages[mp.selectedMsg].myReaction()
+		for i, emoji := range mp.reactPicker {
+			if emoji == mine {
+				mp.reactIdx = i
+			}
+		}
+		return 0
+	}))
+	L.SetGlobal("react_next", L.NewFunction(func(L *lua.LState) int {
+		if len(mp.reactPicker) > 0 {
+			mp.reactIdx = (mp.reactIdx + 1) % len(mp.reactPicker)
+		}
+		return 0
+	}))
+	L.SetGlobal("react_prev", L.NewFunction(func(L *lua.LState) int {
+		if len(mp.reactPicker) > 0 {
+			mp.reactIdx = (mp.reactIdx + len(mp.reactPicker) - 1) % len(mp.reactPicker)
+		}
+		return 0
+	}))
+	L.SetGlobal("react_pick", L.NewFunction(func(L *lua.LState) int {
+		idx := L.OptInt(1, mp.reactIdx+1) - 1
+		if idx >= 0 && idx < len(mp.reactPicker) {
+			mp.reactSelected(mp.reactPicker[idx])
+		}
+		return 0
+	}))
+	L.SetGlobal("unreact_selected", L.NewFunction(func(L *lua.LState) int {
+		mp.reactPicker = nil
+		if !mp.inInput && mp.selectedMsg >= 0 && mp.selectedMsg < len(mp.messages) {
+			if mine := mp.messages[mp.selectedMsg].myReaction(); mine != "" {
+				mp.reactSelected(mine)
+			}
+		}
+		return 0
+	}))
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"sort"
+	"strings"
+	"unicode"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	lua "github.com/yuin/gopher-lua"
+	"golang.org/x/text/unicode/norm"
+)
+
+// searchLimit is how many results the search page shows
+const searchLimit = 50
+
+// messageSearcher is implemented by backends that can search their messages
+// without the network
+type messageSearcher interface {
+	SearchMessages(query string, names map[string]string, limit int) ([]searchResult, error)
+}
+
+type searchResult struct {
+	Chat   Chat
+	Msg    message
+	Sender string
+	Score  int
+}
+
+type searchResultsMsg struct {
+	query   string
+	results []searchResult
+	err     error
+}
+
+// foldText lowercases s and strips its accents, so "Não" finds "nao"
+func foldText(s string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(s) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// searchTerms splits s into folded words
+func searchTerms(s string) []string {
+	return strings.FieldsFunc(foldText(s), func(r rune) bool { return !isWordRune(r) })
+}
+
+// termMatches reports whether a word of text starts with term
+func termMatches(term, text string) bool {
+	for _, word := range searchTerms(text) {
+		if strings.HasPrefix(word, term) {
+			return true
+		}
+	}
+	return false
+}
+
+// senderID is who wrote a message, the member for group messages
+func senderID(m message) string {
+	if m.GroupFrom != "" {
+		return m.GroupFrom
+	}
+	return m.From
+}
+
+// matchMessage scores a message against the search terms, every term has to
+// match. A whole word of the body is worth 3, the start of a word of the
+// body 2 and the sender or chat name 1
+func matchMessage(terms []string, m message, sender, chatName string) (int, bool) {
+	words := searchTerms(m.Body)
+	score := 0
+	for _, term := range terms {
+		best := 0
+		for _, word := range words {
+			if word == term {
+				best = 3
+				break
+			}
+			if strings.HasPrefix(word, term) {
+				best = 2
+			}
+		}
+		if best == 0 && (termMatches(term, sender) || termMatches(term, chatName)) {
+			best = 1
+		}
+		if best == 0 {
+			return 0, false
+		}
+		score += best
+	}
+	return score, true
+}
+
+func newSearchResult(terms []string, chat Chat, m message, names map[string]string) (searchResult, bool) {
+	sender, ok := names[senderID(m)]
+	if !ok {
+		sender = senderID(m)
+	}
+	if m.FromMe {
+		sender = "You"
+	}
+	chatName := chat.Name
+	if chatName == "" {
+		chatName = chat.ID
+	}
+	score, ok := matchMessage(terms, m, sender, chatName)
+	return searchResult{Chat: chat, Msg: m, Sender: sender, Score: score}, ok
+}
+
+// rankResults sorts the best results first, newest first between equals
+func rankResults(results []searchResult, limit int) []searchResult {
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Msg.Timestamp.After(results[j].Msg.Timestamp)
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+type runeSpan struct{ start, end int }
+
+// matchSpans finds the words of runes starting with one of the terms
+func matchSpans(runes []rune, terms []string) []runeSpan {
+	var spans []runeSpan
+	for i := 0; i < len(runes); {
+		if !isWordRune(runes[i]) {
+			i++
+			continue
+		}
+		j := i
+		for j < len(runes) && isWordRune(runes[j]) {
+			j++
+		}
+		word := foldText(string(runes[i:j]))
+		for _, term := range terms {
+			if strings.HasPrefix(word, term) {
+				spans = append(spans, runeSpan{i, j})
+				break
+			}
+		}
+		i = j
+	}
+	return spans
+}
+
+// highlightSpans renders runes[start:end] with the spans highlighted
+func highlightSpans(runes []rune, spans []runeSpan, start, end int) string {
+	var b strings.Builder
+	pos := start
+	for _, m := range spans {
+		if m.end <= start || m.start >= end {
+			continue
+		}
+		from, to := max(m.start, pos), min(m.end, end)
+		b.WriteString(string(runes[pos:from]))
+		b.WriteString(styles["replyHighlight"].Render(string(runes[from:to])))
+		pos = to
+	}
+	b.WriteString(string(runes[pos:end]))
+	return b.String()
+}
+
+// highlightMatches highlights every word of text matching the terms
+func highlightMatches(text string, terms []string) string {
+	runes := []rune(text)
+	return highlightSpans(runes, matchSpans(runes, terms), 0, len(runes))
+}
+
+// highlightStyled renders text with style and the words matching the terms
+// highlighted, so a highlight does not end the style of the rest
+func highlightStyled(text string, terms []string, style lipgloss.Style) string {
+	runes := []rune(text)
+	matches := matchSpans(runes, terms)
+	if len(matches) == 0 {
+		return style.Render(text)
+	}
+	var b strings.Builder
+	pos := 0
+	for _, m := range matches {
+		if m.start > pos {
+			b.WriteString(style.Render(string(runes[pos:m.start])))
+		}
+		b.WriteString(styles["replyHighlight"].Render(string(runes[m.start:m.end])))
+		pos = m.end
+	}
+	if pos < len(runes) {
+		b.WriteString(style.Render(string(runes[pos:])))
+	}
+	return b.String()
+}
+
+// highlightSnippet cuts width runes of body around the first word matching
+// the terms, highlighting every matching word
+func highlightSnippet(body string, terms []string, width int) string {
+	runes := []rune(strings.Join(strings.Fields(body), " "))
+	matches := matchSpans(runes, terms)
+
+	width = max(width, 10)
+	start := 0
+	if len(matches) > 0 {
+		start = max(0, matches[0].start-width/3)
+	}
+	end := min(len(runes), start+width)
+
+	snippet := highlightSpans(runes, matches, start, end)
+	if start > 0 {
+		snippet = "…" + snippet
+	}
+	if end < len(runes) {
+		snippet += "…"
+	}
+	return snippet
+}
+
+// searchMessages runs query against the local copy of the messages
+func searchMessages(b Backend, names map[string]string, query string) tea.Cmd {
+	return func() tea.Msg {
+		searcher, ok := b.(messageSearcher)
+		if !ok {
+			return searchResultsMsg{query: query, err: fmt.Errorf("search needs the local cache, see cache in config.lua")}
+		}
+		results, err := searcher.SearchMessages(query, names, searchLimit)
+		return searchResultsMsg{query: query, results: results, err: err}
+	}
+}
+
+type search_page struct {
+	query     string
+	results   []searchResult
+	selected  int
+	err       error
+	container *pageContainer
+}
+
+func new_search_page(container *pageContainer) search_page {
+	if container == nil {
+		panic("passed nil container")
+	}
+
+	sp := search_page{}
+	sp.container = container
+	return sp
+}
+
+func (sp search_page) Init() tea.Cmd {
+	return nil
+}
+
+// search queues a new search for the current query
+func (sp *search_page) search() {
+	// the app keeps filling id_to_name while the search runs
+	names := maps.Clone(sp.container.app.current.id_to_name)
+	sp.container.commands = append(sp.container.commands, searchMessages(sp.container.app.current.backend, names, sp.query))
+}
+
+func (sp *search_page) registerLuaFuncs() {
+	L := sp.container.app.luaState
+	L.SetGlobal("search_scroll_up", L.NewFunction(func(L *lua.LState) int {
+		if sp.selected > 0 {
+			sp.selected--
+		}
+		return 0
+	}))
+	L.SetGlobal("search_scroll_down", L.NewFunction(func(L *lua.LState) int {
+		if sp.selected < len(sp.results)-1 {
+			sp.selected++
+		}
+		return 0
+	}))
+	L.SetGlobal("search_backspace", L.NewFunction(func(L *lua.LState) int {
+		if sp.query != "" {
+			runes := []rune(sp.query)
+			sp.query = string(runes[:len(runes)-1])
+			sp.search()
+		}
+		return 0
+	}))
+	L.SetGlobal("search_select", L.NewFunction(func(L *lua.LState) int {
+		if sp.selected < len(sp.results) {
+			sp.container.app.luaReturn = "go_messages"
+		}
+		return 0
+	}))
+	L.SetGlobal("search_escape", L.NewFunction(func(L *lua.LState) int {
+		sp.container.app.luaReturn = "go_back"
+		return 0
+	}))
+}
+
+func (sp search_page) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case searchResultsMsg:
+		// answers to older queries arrive late when typing fast
+		if msg.query != sp.query {
+			return sp, nil
+		}
+		sp.results = msg.results
+		sp.err = msg.err
+		sp.selected = 0
+	case tea.KeyMsg:
+		sp.registerLuaFuncs()
+		sp.container.app.luaReturn = ""
+		L := sp.container.app.luaState
+
+		err := L.DoString(fmt.Sprintf(`
+			handled = false
+			local key = %q
+			local f = (search_keybinds or {})[key]
+			if type(f) == "function" then
+				f()
+				handled = true
+			end
+		`, msg.String()))
+		if err != nil {
+			fmt.Println("Lua error:", err)
+		}
+		handled := L.GetGlobal("handled") == lua.LTrue
+		L.SetGlobal("handled", lua.LBool(false))
+
+		switch sp.container.app.luaReturn {
+		case "go_messages":
+			hit := sp.results[sp.selected]
+			mp := new_messages_page(hit.Chat, sp.container)
+			mp.jumpToMsg = hit.Msg.MsgID
+			sp.container.commands = append(sp.container.commands, getMessages(sp.container.app.current.backend, hit.Chat.ID))
+			return sp.container.push(sp, mp), nil
+		case "go_back":
+			return sp.container.pop(sp.container.chatsFallback), nil
+		}
+
+		// keys without a keybind type the query
+		if !handled {
+			switch msg.Type {
+			case tea.KeyRunes:
+				sp.query += string(msg.Runes)
+				sp.search()
+			case tea.KeySpace:
+				sp.query += " "
+				sp.search()
+			}
+		}
+	}
+	return sp, nil
+}
+
+func (sp search_page) View() string {
+	var b strings.Builder
+	b.WriteString("Search: " + sp.query + "\n\n")
+
+	switch {
+	case sp.err != nil:
+		b.WriteString(sp.err.Error())
+		return b.String()
+	case len(searchTerms(sp.query)) == 0:
+		b.WriteString(styles["unselectedStyle"].Render("Type to search the cached messages"))
+		return b.String()
+	case len(sp.results) == 0:
+		b.WriteString(styles["unselectedStyle"].Render("No messages found"))
+		return b.String()
+	}
+
+	// every result takes two lines
+	visible := max(1, (sp.container.app.height-3)/2)
+	start := max(0, sp.selected-visible+1)
+	end := min(len(sp.results), start+visible)
+	terms := searchTerms(sp.query)
+	for i := start; i < end; i++ {
+		r := sp.results[i]
+		chatName := r.Chat.Name
+		if chatName == "" {
+			chatName = r.Chat.ID
+		}
+		header := fmt.Sprintf("%s · %s · %s", chatName, r.Sender, r.Msg.Timestamp.Local().Format("15:04 02/01/2006"))
+		if i == sp.selected {
+			b.WriteString("> " + styles["selectedStyle"].Render(header) + "\n")
+		} else {
+			b.WriteString("  " + styles["unselectedStyle"].Render(header) + "\n")
+		}
+		b.WriteString("    " + highlightSnippet(r.Msg.Body, terms, sp.container.app.width-6) + "\n")
+	}
+	return b.String()
+}
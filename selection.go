@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/atotto/clipboard"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Several messages can be picked for forwarding, deleting, copying or
+// exporting at once: mark_selected toggles one, visual_mode marks every
+// message between where it started and the selected one, like vim's V
+
+// inSelection reports whether messages[idx] is marked or in the visual range
+func (mp messages_page) inSelection(idx int) bool {
+	if idx < 0 || idx >= len(mp.messages) {
+		return false
+	}
+	if mp.marked[mp.messages[idx].MsgID] {
+		return true
+	}
+	if mp.visualFrom == -1 || mp.inInput || mp.selectedMsg < 0 {
+		return false
+	}
+	from, to := min(mp.visualFrom, mp.selectedMsg), max(mp.visualFrom, mp.selectedMsg)
+	return idx >= from && idx <= to
+}
+
+// selection is the indexes of the marked messages and of the visual range
+// in chat order, or the selected message when there are none
+func (mp messages_page) selection() []int {
+	var idxs []int
+	for i := range mp.messages {
+		if mp.inSelection(i) {
+			idxs = append(idxs, i)
+		}
+	}
+	if len(idxs) == 0 && !mp.inInput && mp.selectedMsg >= 0 && mp.selectedMsg < len(mp.messages) {
+		idxs = append(idxs, mp.selectedMsg)
+	}
+	return idxs
+}
+
+// markedCount is how many messages are marked or in the visual range
+func (mp messages_page) markedCount() int {
+	if len(mp.marked) == 0 && mp.visualFrom == -1 {
+		return 0
+	}
+	n := 0
+	for i := range mp.messages {
+		if mp.inSelection(i) {
+			n++
+		}
+	}
+	return n
+}
+
+// clearSelection drops the marks and leaves visual mode
+func (mp *messages_page) clearSelection() {
+	mp.marked = make(map[string]bool)
+	mp.visualFrom = -1
+}
+
+// toggleVisual starts a visual range on the selected message, or keeps the
+// range marked and leaves visual mode
+func (mp *messages_page) toggleVisual() {
+	if mp.visualFrom != -1 {
+		for _, idx := range mp.selection() {
+			if id := mp.messages[idx].MsgID; id != "" {
+				mp.marked[id] = true
+			}
+		}
+		mp.visualFrom = -1
+		return
+	}
+	if !mp.inInput && mp.selectedMsg >= 0 {
+		mp.visualFrom = mp.selectedMsg
+	}
+}
+
+// transcript writes messages as lines like "[2025-08-22 01:27] Alice: lol"
+func (mp messages_page) transcript(idxs []int) string {
+	var b strings.Builder
+	for _, idx := range idxs {
+		msg := mp.messages[idx]
+		body := msg.getMediaPrefix() + msg.Body
+		fmt.Fprintf(&b, "[%s] %s: %s\n", msg.Timestamp.Local().Format("2006-01-02 15:04"), mp.senderName(msg), body)
+	}
+	return b.String()
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^\w.-]+`)
+
+// exportPath is where export_selected writes when given no path, in the
+// current directory and named after the chat
+func (mp messages_page) exportPath() string {
+	name := mp.from_chat.Name
+	if name == "" {
+		name = mp.from_chat.ID
+	}
+	name = strings.Trim(unsafeFileChars.ReplaceAllString(name, "_"), "_")
+	return fmt.Sprintf("whats-cli-%s-%s.txt", name, time.Now().Format("20060102-150405"))
+}
+
+// registerSelectionFuncs exposes picking several messages and the batch
+// actions that only need the selection to lua
+func (mp *messages_page) registerSelectionFuncs() {
+	L := mp.container.app.luaState
+
+	L.SetGlobal("visual_mode", L.NewFunction(func(L *lua.LState) int {
+		if mp.inInput {
+			mp.container.app.luaReturn = "type"
+			return 0
+		}
+		mp.toggleVisual()
+		return 0
+	}))
+
+	L.SetGlobal("selection_count", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LNumber(len(mp.selection())))
+		return 1
+	}))
+
+	L.SetGlobal("copy_selected", L.NewFunction(func(L *lua.LState) int {
+		idxs := mp.selection()
+		if mp.inInput || len(idxs) == 0 {
+			mp.container.app.luaReturn = "type"
+			return 0
+		}
+		text := "Copied " + pluralMessages(len(idxs))
+		if err := clipboard.WriteAll(mp.transcript(idxs)); err != nil {
+			text = "Could not copy: " + err.Error()
+		} else {
+			mp.clearSelection()
+		}
+		mp.container.commands = append(mp.container.commands, flash(updateFlashMsg{msg: text, count: 6}))
+		return 0
+	}))
+
+	L.SetGlobal("export_selected", L.NewFunction(func(L *lua.LState) int {
+		idxs := mp.selection()
+		if mp.inInput || len(idxs) == 0 {
+			mp.container.app.luaReturn = "type"
+			return 0
+		}
+		path := L.OptString(1, "")
+		if path == "" {
+			path = mp.exportPath()
+		}
+		text := "Exported " + pluralMessages(len(idxs)) + " to " + path
+		if err := os.WriteFile(path, []byte(mp.transcript(idxs)), 0644); err != nil {
+			text = "Could not export: " + err.Error()
+		} else {
+			if abs, err := filepath.Abs(path); err == nil {
+				text = "Exported " + pluralMessages(len(idxs)) + " to " + abs
+			}
+			mp.clearSelection()
+		}
+		mp.container.commands = append(mp.container.commands, flash(updateFlashMsg{msg: text, count: 6}))
+		return 0
+	}))
+}
+
+func pluralMessages(n int) string {
+	if n == 1 {
+		return "1 message"
+	}
+	return fmt.Sprintf("%d messages", n)
+}
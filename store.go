@@ -0,0 +1,415 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// store is the local copy of chats and messages, kept in a bbolt file with
+// one bucket per account:
+//
+//	[clientID]/chats            chat id -> Chat
+//	[clientID]/messages/[chat]  message id -> message
+//	[clientID]/index            search postings, see indexKey
+type store struct {
+	db *bolt.DB
+}
+
+const (
+	chatsBucket    = "chats"
+	messagesBucket = "messages"
+	indexBucket    = "index"
+)
+
+func openStore(path string) (*store, error) {
+	// another whats-cli holding the file would block us forever
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	st := &store{db: db}
+	if err := st.buildMissingIndexes(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return st, nil
+}
+
+func (s *store) Close() error {
+	return s.db.Close()
+}
+
+// bucket walks (creating them if needed) the nested buckets at path
+func bucket(tx *bolt.Tx, path ...string) (*bolt.Bucket, error) {
+	b, err := tx.CreateBucketIfNotExists([]byte(path[0]))
+	for _, name := range path[1:] {
+		if err != nil {
+			return nil, err
+		}
+		b, err = b.CreateBucketIfNotExists([]byte(name))
+	}
+	return b, err
+}
+
+// readBucket walks the nested buckets at path, nil if any is missing
+func readBucket(tx *bolt.Tx, path ...string) *bolt.Bucket {
+	b := tx.Bucket([]byte(path[0]))
+	for _, name := range path[1:] {
+		if b == nil {
+			return nil
+		}
+		b = b.Bucket([]byte(name))
+	}
+	return b
+}
+
+func (s *store) saveChats(clientID string, chats []Chat) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := bucket(tx, clientID, chatsBucket)
+		if err != nil {
+			return err
+		}
+		for _, c := range chats {
+			value, err := json.Marshal(c)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(c.ID), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// chats returns the cached chats pinned first, then by most recent message
+func (s *store) chats(clientID string) ([]Chat, error) {
+	var chats []Chat
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := readBucket(tx, clientID, chatsBucket)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(_, value []byte) error {
+			var c Chat
+			if err := json.Unmarshal(value, &c); err != nil {
+				return err
+			}
+			chats = append(chats, c)
+			return nil
+		})
+	})
+	sort.SliceStable(chats, func(i, j int) bool {
+		if chats[i].IsPinned != chats[j].IsPinned {
+			return chats[i].IsPinned
+		}
+		return chats[i].LastMessage.Timestamp.After(chats[j].LastMessage.Timestamp)
+	})
+	return chats, err
+}
+
+// saveMessages adds or updates messages of a chat, messages that are not
+// passed are kept
+func (s *store) saveMessages(clientID, chatID string, msgs []message) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := bucket(tx, clientID, messagesBucket, chatID)
+		if err != nil {
+			return err
+		}
+		index, err := bucket(tx, clientID, indexBucket)
+		if err != nil {
+			return err
+		}
+		for _, m := range msgs {
+			if m.MsgID == "" {
+				continue
+			}
+			value, err := json.Marshal(m)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(m.MsgID), value); err != nil {
+				return err
+			}
+			if err := indexMessage(index, chatID, m); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// messages returns the cached messages of a chat, oldest first
+func (s *store) messages(clientID, chatID string) ([]message, error) {
+	var msgs []message
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := readBucket(tx, clientID, messagesBucket, chatID)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(_, value []byte) error {
+			var m message
+			if err := json.Unmarshal(value, &m); err != nil {
+				return err
+			}
+			msgs = append(msgs, m)
+			return nil
+		})
+	})
+	sort.SliceStable(msgs, func(i, j int) bool {
+		return msgs[i].Timestamp.Before(msgs[j].Timestamp)
+	})
+	return msgs, err
+}
+
+// deleteMessage removes a message of a chat, its search postings are left
+// behind like those of edits
+func (s *store) deleteMessage(clientID, chatID, msgID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := readBucket(tx, clientID, messagesBucket, chatID)
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(msgID))
+	})
+}
+
+// cacheReader is implemented by backends that keep a local copy of their
+// data, so pages can show it before the network answers
+type cacheReader interface {
+	CachedChats() ([]Chat, error)
+	CachedMessages(chatID string) ([]message, error)
+}
+
+// cachingBackend saves everything the wrapped Backend returns to the store,
+// and answers from the store when the backend can't be reached. Messages
+// are merged with the cached ones, so history builds up over time
+type cachingBackend struct {
+	Backend
+	store    *store
+	clientID string
+}
+
+func newCachingBackend(b Backend, st *store, clientID string) *cachingBackend {
+	return &cachingBackend{Backend: b, store: st, clientID: clientID}
+}
+
+func (cb *cachingBackend) Chats() ([]Chat, error) {
+	chats, err := cb.Backend.Chats()
+	if err != nil {
+		cached, cerr := cb.store.chats(cb.clientID)
+		if cerr != nil || len(cached) == 0 {
+			return nil, err
+		}
+		log.Printf("Backend unreachable, showing cached chats: %v", err)
+		return cached, nil
+	}
+	if err := cb.store.saveChats(cb.clientID, chats); err != nil {
+		log.Printf("Could not cache chats: %v", err)
+	}
+	return chats, nil
+}
+
+// Messages merges the page the backend sent into the cache and answers the
+// same page from it, which may reach older history than the backend has
+func (cb *cachingBackend) Messages(chatID, before string, limit int) ([]message, error) {
+	msgs, err := cb.Backend.Messages(chatID, before, limit)
+	if err != nil {
+		cached, cerr := cb.store.messages(cb.clientID, chatID)
+		cached = pageBefore(cached, before, limit)
+		if cerr != nil || len(cached) == 0 {
+			return nil, err
+		}
+		log.Printf("Backend unreachable, showing cached messages: %v", err)
+		return cached, nil
+	}
+	if err := cb.store.saveMessages(cb.clientID, chatID, msgs); err != nil {
+		log.Printf("Could not cache messages: %v", err)
+		return msgs, nil
+	}
+	if before != "" && len(msgs) == limit {
+		return msgs, nil
+	}
+	// the cache may hold older history than what the backend returned
+	cached, err := cb.store.messages(cb.clientID, chatID)
+	if err != nil {
+		return msgs, nil
+	}
+	if page := pageBefore(cached, before, limit); len(page) > len(msgs) {
+		return page, nil
+	}
+	return msgs, nil
+}
+
+// DeleteMessage also drops messages deleted only for us from the cache,
+// otherwise they would come back with the cached history
+func (cb *cachingBackend) DeleteMessage(chatID, msgID string, everyone bool) error {
+	if err := cb.Backend.DeleteMessage(chatID, msgID, everyone); err != nil {
+		return err
+	}
+	if !everyone {
+		if err := cb.store.deleteMessage(cb.clientID, chatID, msgID); err != nil {
+			log.Printf("Could not remove deleted message from cache: %v", err)
+		}
+	}
+	return nil
+}
+
+func (cb *cachingBackend) CachedChats() ([]Chat, error) {
+	return cb.store.chats(cb.clientID)
+}
+
+func (cb *cachingBackend) CachedMessages(chatID string) ([]message, error) {
+	return cb.store.messages(cb.clientID, chatID)
+}
+
+func (cb *cachingBackend) SearchMessages(query string, names map[string]string, limit int) ([]searchResult, error) {
+	return cb.store.search(cb.clientID, query, names, limit)
+}
+
+// indexKey is a search posting: kind 'w' for a word of the body or 's' for
+// the sender id, then the word or id, the chat id and the message id, split
+// by zero bytes so a prefix seek finds every message with a word
+func indexKey(kind byte, term, chatID, msgID string) []byte {
+	return []byte(string(kind) + term + "\x00" + chatID + "\x00" + msgID)
+}
+
+// indexMessage adds the postings of a message. Postings of words an edit
+// removed are left behind, search checks every hit against the message
+func indexMessage(index *bolt.Bucket, chatID string, m message) error {
+	for _, word := range searchTerms(m.Body) {
+		if err := index.Put(indexKey('w', word, chatID, m.MsgID), nil); err != nil {
+			return err
+		}
+	}
+	if sender := senderID(m); sender != "" {
+		return index.Put(indexKey('s', sender, chatID, m.MsgID), nil)
+	}
+	return nil
+}
+
+// buildMissingIndexes indexes the accounts cached before search existed
+func (s *store) buildMissingIndexes() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(clientID []byte, account *bolt.Bucket) error {
+			msgs := account.Bucket([]byte(messagesBucket))
+			if msgs == nil || account.Bucket([]byte(indexBucket)) != nil {
+				return nil
+			}
+			index, err := account.CreateBucket([]byte(indexBucket))
+			if err != nil {
+				return err
+			}
+			log.Printf("Building search index for %s", clientID)
+			return msgs.ForEachBucket(func(chatID []byte) error {
+				return msgs.Bucket(chatID).ForEach(func(_, value []byte) error {
+					var m message
+					if err := json.Unmarshal(value, &m); err != nil {
+						return err
+					}
+					return indexMessage(index, string(chatID), m)
+				})
+			})
+		})
+	})
+}
+
+type searchHit struct {
+	chatID string
+	msgID  string
+}
+
+// scanPostings adds to hits every message whose posting starts with prefix
+func scanPostings(index *bolt.Bucket, prefix string, hits map[searchHit]bool) {
+	c := index.Cursor()
+	for k, _ := c.Seek([]byte(prefix)); k != nil && strings.HasPrefix(string(k), prefix); k, _ = c.Next() {
+		parts := strings.Split(string(k), "\x00")
+		if len(parts) < 3 {
+			continue
+		}
+		hits[searchHit{parts[len(parts)-2], parts[len(parts)-1]}] = true
+	}
+}
+
+// search returns the best cached messages matching every word of query, in
+// their body, sender name or chat name. names maps sender ids to names
+func (s *store) search(clientID, query string, names map[string]string, limit int) ([]searchResult, error) {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	var results []searchResult
+	err := s.db.View(func(tx *bolt.Tx) error {
+		index := readBucket(tx, clientID, indexBucket)
+		msgs := readBucket(tx, clientID, messagesBucket)
+		if index == nil || msgs == nil {
+			return nil
+		}
+		chats := make(map[string]Chat)
+		if b := readBucket(tx, clientID, chatsBucket); b != nil {
+			b.ForEach(func(_, value []byte) error {
+				var c Chat
+				if json.Unmarshal(value, &c) == nil {
+					chats[c.ID] = c
+				}
+				return nil
+			})
+		}
+
+		// candidates must match every term somewhere, matchMessage ranks them
+		var candidates map[searchHit]bool
+		for _, term := range terms {
+			hits := make(map[searchHit]bool)
+			scanPostings(index, "w"+term, hits)
+			for id, name := range names {
+				if termMatches(term, name) {
+					scanPostings(index, "s"+id+"\x00", hits)
+				}
+			}
+			for id, c := range chats {
+				if b := msgs.Bucket([]byte(id)); b != nil && termMatches(term, c.Name) {
+					b.ForEach(func(msgID, _ []byte) error {
+						hits[searchHit{id, string(msgID)}] = true
+						return nil
+					})
+				}
+			}
+			if candidates == nil {
+				candidates = hits
+				continue
+			}
+			for hit := range candidates {
+				if !hits[hit] {
+					delete(candidates, hit)
+				}
+			}
+		}
+
+		for hit := range candidates {
+			b := msgs.Bucket([]byte(hit.chatID))
+			if b == nil {
+				continue
+			}
+			value := b.Get([]byte(hit.msgID))
+			var m message
+			if value == nil || json.Unmarshal(value, &m) != nil {
+				continue
+			}
+			chat, ok := chats[hit.chatID]
+			if !ok {
+				chat.ID = hit.chatID
+			}
+			if r, ok := newSearchResult(terms, chat, m, names); ok {
+				results = append(results, r)
+			}
+		}
+		return nil
+	})
+	return rankResults(results, limit), err
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// startTransport starts feeding the events of every account into cmdChan,
+// using the transport chosen in the config
+func startTransport(cfg config, accounts []*account, cmdChan chan tea.Msg) error {
+	switch cfg.Transport {
+	case "webhook":
+		return startWebhookListener(cfg, cmdChan)
+	case "poll":
+		interval, err := time.ParseDuration(cfg.PollInterval)
+		if err != nil || interval <= 0 {
+			return fmt.Errorf("invalid poll_interval %q", cfg.PollInterval)
+		}
+		for _, acc := range accounts {
+			go pollChats(acc, interval, cmdChan)
+		}
+		return nil
+	case "sse":
+		for _, acc := range accounts {
+			go streamEvents(streamURL(cfg, acc), acc.clientID, cmdChan)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown transport %q", cfg.Transport)
+}
+
+// pollChats fetches the chats of an account every interval and turns each
+// chat whose last message changed into a new message event, ignoring our own
+// messages. The first fetch only records where every chat is.
+func pollChats(acc *account, interval time.Duration, cmdChan chan tea.Msg) {
+	var last map[string]string // chat id -> id of its last message
+	for ; ; time.Sleep(interval) {
+		chats, err := acc.backend.Chats()
+		if err != nil {
+			log.Printf("Polling chats of %s failed: %v", acc.name, err)
+			continue
+		}
+
+		current := make(map[string]string, len(chats))
+		for _, c := range chats {
+			current[c.ID] = c.LastMessage.MsgID
+			if last == nil || c.LastMessage.MsgID == "" || c.LastMessage.FromMe || last[c.ID] == c.LastMessage.MsgID {
+				continue
+			}
+			cmdChan <- chatToWebhookMsg(acc.clientID, c)
+		}
+		last = current
+	}
+}
+
+// chatToWebhookMsg builds the event a webhook would have sent for the last
+// message of a chat
+func chatToWebhookMsg(clientID string, c Chat) webhookMsg {
+	var hook webhookMsg
+	hook.ClientID = clientID
+	hook.Chat.ID = c.ID
+	hook.Chat.Name = c.Name
+	hook.Chat.UnreadCount = c.UnreadCount
+	hook.Chat.LastMessage = c.LastMessage.Body
+	hook.Chat.IsArchived = c.IsArchived
+	hook.Chat.IsGroup = c.IsGroup
+	hook.Chat.IsMuted = c.IsMuted
+	hook.Chat.IsReadOnly = c.IsReadOnly
+	hook.Chat.IsPinned = c.IsPinned
+
+	m := c.LastMessage
+	hook.Message.ID = m.MsgID
+	hook.Message.From = m.From
+	if m.GroupFrom != "" {
+		hook.Message.GroupMemberFrom = &m.GroupFrom
+	}
+	hook.Message.FromMe = m.FromMe
+	hook.Message.Body = m.Body
+	hook.Message.Timestamp = m.Timestamp
+	hook.Message.HasMedia = m.HasMedia
+	hook.Message.IsQuote = m.IsResponse
+	hook.Message.QuoteID = m.ResponseToID
+	hook.Message.IsForwarded = m.IsForwarded
+	hook.Message.MentionedIDs = m.MentionedIDs
+	return hook
+}
+
+// streamURL is the server-sent events endpoint of an account, unless
+// config.stream_url is set it is /client/{id}/events on the account backend
+func streamURL(cfg config, acc *account) string {
+	if cfg.StreamURL != "" {
+		return strings.ReplaceAll(cfg.StreamURL, "{clientId}", url.PathEscape(acc.clientID))
+	}
+	return strings.TrimSuffix(acc.backendURL, "/") + "/client/" + url.PathEscape(acc.clientID) + "/events"
+}
+
+// streamEvents reads server-sent events from streamURL forever, every event's
+// data being a webhook body, and reconnects with a backoff when it drops
+func streamEvents(streamURL, clientID string, cmdChan chan tea.Msg) {
+	backoff := time.Second
+	for {
+		start := time.Now()
+		err := readEventStream(streamURL, clientID, cmdChan)
+		log.Printf("Event stream %s closed: %v", streamURL, err)
+
+		if time.Since(start) > time.Minute {
+			backoff = time.Second
+		}
+		time.Sleep(backoff)
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+func readEventStream(streamURL, clientID string, cmdChan chan tea.Msg) error {
+	req, err := http.NewRequest(http.MethodGet, streamURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("server answered %s", res.Status)
+	}
+
+	scanner := bufio.NewScanner(res.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	var data strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			// a blank line ends the event
+			if data.Len() == 0 {
+				continue
+			}
+			event, err := parseWebhookEvent([]byte(data.String()), clientID)
+			if err != nil {
+				log.Printf("Invalid event from %s: %v", streamURL, err)
+			} else {
+				cmdChan <- event
+			}
+			data.Reset()
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteString("\n")
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("stream ended")
+}
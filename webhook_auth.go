@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// signatureHeader carries the hex HMAC-SHA256 of the request body, keyed with
+// config.webhook_secret, optionally prefixed with "sha256="
+const signatureHeader = "X-Whatshttp-Signature"
+
+// webhookAuth decides which webhook requests are trusted
+type webhookAuth struct {
+	secret []byte
+	allow  []*net.IPNet // empty allows every address
+}
+
+func newWebhookAuth(cfg config) (*webhookAuth, error) {
+	wa := &webhookAuth{secret: []byte(cfg.WebhookSecret)}
+	for _, entry := range strings.Split(cfg.WebhookAllow, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if strings.Contains(entry, ":") {
+				entry += "/128"
+			} else {
+				entry += "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid webhook_allow entry %q", entry)
+		}
+		wa.allow = append(wa.allow, ipNet)
+	}
+	return wa, nil
+}
+
+// checkSource rejects requests coming from outside the allowlist
+func (wa *webhookAuth) checkSource(r *http.Request) error {
+	if len(wa.allow) == 0 {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	for _, ipNet := range wa.allow {
+		if ip != nil && ipNet.Contains(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("address not in webhook_allow")
+}
+
+// checkSignature verifies the body was signed with the shared secret, when
+// no secret is configured every body is accepted
+func (wa *webhookAuth) checkSignature(r *http.Request, body []byte) error {
+	if len(wa.secret) == 0 {
+		return nil
+	}
+	sig := strings.TrimPrefix(r.Header.Get(signatureHeader), "sha256=")
+	if sig == "" {
+		return fmt.Errorf("missing %s header", signatureHeader)
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return fmt.Errorf("malformed %s header", signatureHeader)
+	}
+	mac := hmac.New(sha256.New, wa.secret)
+	mac.Write(body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return fmt.Errorf("bad signature")
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestCheckSignature(t *testing.T) {
+	const body = `{"event":"message_ack","chatId":"1@c.us","messageId":"m1","ack":3}`
+	tests := []struct {
+		name   string
+		secret string
+		header string // "" leaves the header out
+		ok     bool
+	}{
+		{"no secret, no header", "", "", true},
+		{"no secret, any header", "", "garbage", true},
+		{"plain hex", "s3cret", sign("s3cret", body), true},
+		{"sha256= prefix", "s3cret", "sha256=" + sign("s3cret", body), true},
+		{"other secret", "s3cret", "sha256=" + sign("other", body), false},
+		{"other body", "s3cret", sign("s3cret", body+" "), false},
+		{"not hex", "s3cret", "sha256=not-hex", false},
+		{"missing header", "s3cret", "", false},
+		{"only the prefix", "s3cret", "sha256=", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wa, err := newWebhookAuth(config{WebhookSecret: tt.secret})
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest("POST", "/whatshttp/webhook", strings.NewReader(body))
+			if tt.header != "" {
+				r.Header.Set(signatureHeader, tt.header)
+			}
+			err = wa.checkSignature(r, []byte(body))
+			if tt.ok && err != nil {
+				t.Errorf("rejected: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Error("accepted")
+			}
+		})
+	}
+}
+
+func TestCheckSource(t *testing.T) {
+	tests := []struct {
+		name   string
+		allow  string
+		remote string
+		ok     bool
+	}{
+		{"empty allows all", "", "203.0.113.7:5000", true},
+		{"single ip", "127.0.0.1", "127.0.0.1:5000", true},
+		{"single ip, other address", "127.0.0.1", "127.0.0.2:5000", false},
+		{"cidr", "10.0.0.0/8", "10.20.30.40:5000", true},
+		{"cidr, outside", "10.0.0.0/8", "11.0.0.1:5000", false},
+		{"list with spaces", " 127.0.0.1 , 192.168.0.0/16 ", "192.168.1.9:5000", true},
+		{"list, outside", "127.0.0.1,192.168.0.0/16", "172.16.0.1:5000", false},
+		{"ipv6", "::1", "[::1]:5000", true},
+		{"ipv6 cidr, outside", "fd00::/8", "[2001:db8::1]:5000", false},
+		{"no port", "127.0.0.1", "127.0.0.1", true},
+		{"unparsable address", "127.0.0.1", "somewhere", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wa, err := newWebhookAuth(config{WebhookAllow: tt.allow})
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest("POST", "/whatshttp/webhook", nil)
+			r.RemoteAddr = tt.remote
+			err = wa.checkSource(r)
+			if tt.ok && err != nil {
+				t.Errorf("rejected: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Error("accepted")
+			}
+		})
+	}
+}
+
+func TestNewWebhookAuthInvalidAllow(t *testing.T) {
+	for _, allow := range []string{"localhost", "10.0.0.0/33", "127.0.0.1,not-an-ip", "::g"} {
+		if _, err := newWebhookAuth(config{WebhookAllow: allow}); err == nil {
+			t.Errorf("webhook_allow %q accepted", allow)
+		}
+	}
+}
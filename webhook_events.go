@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// webhookEnvelope is the common shape of every webhook payload. The "event"
+// field says what happened, payloads without one are new messages
+type webhookEnvelope struct {
+	Event        string   `json:"event"`
+	ClientID     string   `json:"clientId"`
+	ChatID       string   `json:"chatId"`
+	MsgID        string   `json:"messageId"`
+	Ack          int      `json:"ack"`
+	Body         string   `json:"body"`
+	Reaction     string   `json:"reaction"`
+	SenderID     string   `json:"senderId"`
+	State        string   `json:"state"`
+	Action       string   `json:"action"`
+	Participants []string `json:"participants"`
+	Author       string   `json:"author"`
+	Reason       string   `json:"reason"`
+}
+
+// msgAckMsg is sent when a message changes delivery state, Ack follows
+// whatsapp: 1 sent to server, 2 delivered, 3 read, 4 played
+type msgAckMsg struct {
+	ClientID string `json:"clientId"`
+	ChatID   string `json:"chatId"`
+	MsgID    string `json:"messageId"`
+	Ack      int    `json:"ack"`
+}
+
+type msgRevokedMsg struct {
+	ClientID string `json:"clientId"`
+	ChatID   string `json:"chatId"`
+	MsgID    string `json:"messageId"`
+}
+
+type msgEditedMsg struct {
+	ClientID string `json:"clientId"`
+	ChatID   string `json:"chatId"`
+	MsgID    string `json:"messageId"`
+	Body     string `json:"body"`
+}
+
+// reactionMsg is sent when someone reacts to a message, an empty Reaction
+// means the reaction was removed
+type reactionMsg struct {
+	ClientID string `json:"clientId"`
+	ChatID   string `json:"chatId"`
+	MsgID    string `json:"messageId"`
+	SenderID string `json:"senderId"`
+	Reaction string `json:"reaction"`
+}
+
+// chatStateMsg covers typing and presence: State is one of composing,
+// recording, paused, available or unavailable
+type chatStateMsg struct {
+	ClientID string `json:"clientId"`
+	ChatID   string `json:"chatId"`
+	SenderID string `json:"senderId"`
+	State    string `json:"state"`
+}
+
+// groupMembersMsg is sent when people join, leave or change role in a
+// group. Action is one of add, remove, leave, promote or demote
+type groupMembersMsg struct {
+	ClientID     string   `json:"clientId"`
+	ChatID       string   `json:"chatId"`
+	Action       string   `json:"action"`
+	Participants []string `json:"participants"`
+	Author       string   `json:"author"`
+}
+
+type disconnectedMsg struct {
+	ClientID string `json:"clientId"`
+	Reason   string `json:"reason"`
+}
+
+// accountEvent is implemented by every webhook event, so the app can route
+// it to the account it belongs to
+type accountEvent interface {
+	eventClientID() string
+}
+
+func (m webhookMsg) eventClientID() string      { return m.ClientID }
+func (m msgAckMsg) eventClientID() string       { return m.ClientID }
+func (m msgRevokedMsg) eventClientID() string   { return m.ClientID }
+func (m msgEditedMsg) eventClientID() string    { return m.ClientID }
+func (m reactionMsg) eventClientID() string     { return m.ClientID }
+func (m chatStateMsg) eventClientID() string    { return m.ClientID }
+func (m groupMembersMsg) eventClientID() string { return m.ClientID }
+func (m disconnectedMsg) eventClientID() string { return m.ClientID }
+
+// errUnknownEvent is returned for events this version does not handle, newer
+// whatshttp servers may send some
+var errUnknownEvent = errors.New("unknown event")
+
+// parseWebhookEvent turns a webhook body into its typed tea.Msg. clientID,
+// when not empty, overrides the one in the payload
+func parseWebhookEvent(body []byte, clientID string) (accountEvent, error) {
+	var env webhookEnvelope
+	if err := json.Unmarshal(body, &env); err != nil {
+		return nil, err
+	}
+	if clientID != "" {
+		env.ClientID = clientID
+	}
+
+	switch env.Event {
+	case "", "message":
+		var hook webhookMsg
+		if err := json.Unmarshal(body, &hook); err != nil {
+			return nil, err
+		}
+		hook.ClientID = env.ClientID
+		return hook, nil
+	case "message_ack":
+		return msgAckMsg{env.ClientID, env.ChatID, env.MsgID, env.Ack}, nil
+	case "message_revoked":
+		return msgRevokedMsg{env.ClientID, env.ChatID, env.MsgID}, nil
+	case "message_edited":
+		return msgEditedMsg{env.ClientID, env.ChatID, env.MsgID, env.Body}, nil
+	case "reaction":
+		return reactionMsg{env.ClientID, env.ChatID, env.MsgID, env.SenderID, env.Reaction}, nil
+	case "chat_state":
+		return chatStateMsg{env.ClientID, env.ChatID, env.SenderID, env.State}, nil
+	case "group_members":
+		return groupMembersMsg{env.ClientID, env.ChatID, env.Action, env.Participants, env.Author}, nil
+	case "disconnected":
+		return disconnectedMsg{env.ClientID, env.Reason}, nil
+	}
+	return nil, fmt.Errorf("%w %q", errUnknownEvent, env.Event)
+}
+
+// eventHookName is the lua hook called with each event, new messages keep
+// going through onMsg
+func eventHookName(event accountEvent) string {
+	switch event.(type) {
+	case msgAckMsg:
+		return "onAck"
+	case msgRevokedMsg:
+		return "onRevoke"
+	case msgEditedMsg:
+		return "onEdit"
+	case reactionMsg:
+		return "onReaction"
+	case chatStateMsg:
+		return "onChatState"
+	case groupMembersMsg:
+		return "onGroupMembers"
+	case disconnectedMsg:
+		return "onDisconnect"
+	}
+	return ""
+}
+
+// runEventHook calls hooks[name](event) if the user defined it
+func runEventHook(L *lua.LState, event accountEvent) {
+	name := eventHookName(event)
+	if name == "" {
+		return
+	}
+	str, err := struct_to_lua_table(event)
+	if err != nil {
+		panic(err.Error())
+	}
+	luaScript := fmt.Sprintf(`
+		if hooks and type(hooks[%q]) == "function" then
+			hooks[%q](%v)
+		end
+	`, name, name, str)
+	if err := L.DoString(luaScript); err != nil {
+		panic("Lua error:" + err.Error() + "\n" + luaScript)
+	}
+}
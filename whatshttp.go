@@ -1,19 +1,32 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
-
-	// "fmt"
+	"io"
 	"log"
+	"mime"
+	"mime/multipart"
+	"net"
 	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
 )
 
 type webhookMsg struct {
+	// ClientID is the whatshttp client the event came from, taken from the
+	// payload or from the /whatshttp/webhook/{clientId} path
+	ClientID string `json:"clientId"`
+
 	Chat struct {
 		ID             string `json:"id"`
 		Name           string `json:"name"`
@@ -42,54 +55,362 @@ type webhookMsg struct {
 	} `json:"message"`
 }
 
-func startWebhookListener(cmdChan chan tea.Msg) {
-	http.HandleFunc("/whatshttp/webhook", func(w http.ResponseWriter, r *http.Request) {
+func startWebhookListener(cfg config, cmdChan chan tea.Msg) error {
+	auth, err := newWebhookAuth(cfg)
+	if err != nil {
+		return err
+	}
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != "POST" {
 			http.Error(w, "must POST", http.StatusNotFound)
 			return
 		}
 
-		var hook webhookMsg
-		if err := json.NewDecoder(r.Body).Decode(&hook); err != nil {
-			http.Error(w, "invalid JSON", http.StatusBadRequest)
+		if err := auth.checkSource(r); err != nil {
+			log.Printf("Rejected webhook from %s: %v", r.RemoteAddr, err)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, 16<<20))
+		if err != nil {
+			http.Error(w, "could not read body", http.StatusBadRequest)
+			return
+		}
+		if err := auth.checkSignature(r, body); err != nil {
+			log.Printf("Rejected webhook from %s: %v", r.RemoteAddr, err)
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+
+		event, err := parseWebhookEvent(body, r.PathValue("clientId"))
+		if errors.Is(err, errUnknownEvent) {
+			// a newer whatshttp may send events this version does not know
+			log.Printf("Ignored webhook from %s: %v", r.RemoteAddr, err)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if err != nil {
+			log.Printf("Invalid webhook from %s: %v", r.RemoteAddr, err)
+			http.Error(w, "invalid event", http.StatusBadRequest)
 			return
 		}
 		
-		cmdChan <- hook;
+		cmdChan <- event;
 
 		w.WriteHeader(http.StatusOK)
-	})
+	}
+	http.HandleFunc("/whatshttp/webhook", handler)
+	http.HandleFunc("/whatshttp/webhook/{clientId}", handler)
 
 	go func() {
-		log.Println("Starting webhook server on :4000")
-		if err := http.ListenAndServe(":4000", nil); err != nil {
+		log.Println("Starting webhook server on " + cfg.WebhookAddr)
+		if err := http.ListenAndServe(cfg.WebhookAddr, nil); err != nil {
 			log.Fatalf("Webhook listener failed: %v", err)
 		}
 	}()
+	return nil
 }
 
-func validateBackend() error {
-	resp, err := http.Get(baseURL + "/client/1")
-	if err != nil {
-		err = errors.New(fmt.Sprintf("Failed to connect to backend at %s: %v", baseURL, err))
-		return err
+// validateBackend checks the backend is reachable and returns its WhatsApp
+// session, which may be ready or still need to be logged in
+func validateBackend(b Backend) (client, error) {
+	return b.Client()
+}
+
+// registerWebhook points the webhook of a session linked before whats-cli
+// started at the url of its account. Events posted to the plain
+// /whatshttp/webhook url carry no client id and would all go to the first
+// account
+func registerWebhook(cfg config, acc *account, c client) {
+	if cfg.Transport != "webhook" {
+		return
+	}
+	hook := webhookURL(cfg, acc)
+	if c.WebHook == hook {
+		return
+	}
+	if err := acc.backend.Login(hook); err != nil {
+		log.Printf("Warning: could not register the webhook of account %s, its events may go to another account: %v", acc.name, err)
 	}
-	type client struct {
-		ClientId string `json:"clientId"`
-		Name string `json:"name"`
-		Ready bool `json:"ready"`
-		Qr string `json:"qr"` // base64 image data
-		WebHook string `json:"webHook"`
+}
+
+// webhookURL is the url whatshttp should post the events of an account to.
+// Unless config.webhook_url is set it points at this machine's LAN address
+// and the port of config.webhook_addr
+func webhookURL(cfg config, acc *account) string {
+	base := cfg.WebhookURL
+	if base == "" {
+		host, port, err := net.SplitHostPort(cfg.WebhookAddr)
+		if err != nil {
+			port = "4000"
+		}
+		if host == "" || host == "0.0.0.0" || host == "::" {
+			host = localIP()
+		}
+		base = "http://" + net.JoinHostPort(host, port) + "/whatshttp/webhook"
 	}
+	return strings.TrimSuffix(base, "/") + "/" + url.PathEscape(acc.clientID)
+}
+
+// localIP returns the address this machine uses to reach the network,
+// no packets are sent by dialing udp
+func localIP() string {
+	conn, err := net.Dial("udp", "8.8.8.8:80")
+	if err != nil {
+		return "localhost"
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String()
+}
+
+// whatshttpBackend is the Backend adapter for the whatshttp REST server
+type whatshttpBackend struct {
+	baseURL  string
+	clientID string
+}
+
+func newWhatshttpBackend(baseURL, clientID string) *whatshttpBackend {
+	return &whatshttpBackend{baseURL: baseURL, clientID: clientID}
+}
+
+// url builds an url under /client/{clientID} of the whatshttp server
+func (w *whatshttpBackend) url(format string, a ...any) string {
+	return w.baseURL + "/client/" + w.clientID + fmt.Sprintf(format, a...)
+}
+
+func (w *whatshttpBackend) Client() (client, error) {
 	var c client
+	resp, err := http.Get(w.url(""))
+	if err != nil {
+		err = errors.New(fmt.Sprintf("Failed to connect to backend at %s: %v", w.baseURL, err))
+		return c, err
+	}
+	defer resp.Body.Close()
 	if err := json.NewDecoder(resp.Body).Decode(&c); err != nil {
 		err = errors.New(fmt.Sprintf("Failed to decode backend response: %v", err))
+		return c, err
+	}
+	return c, nil
+}
+
+func (w *whatshttpBackend) Login(webHook string) error {
+	q := url.Values{}
+	q.Set("clientId", w.clientID)
+	if webHook != "" {
+		q.Set("webHook", webHook)
+	}
+	res, err := http.Get(w.baseURL + "/client/qrCode?" + q.Encode())
+	if err != nil {
+		return fmt.Errorf("failed to start session: %w", err)
+	}
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+	if res.StatusCode >= 400 {
+		return fmt.Errorf("failed to start session: %s", res.Status)
+	}
+	return nil
+}
+
+func (w *whatshttpBackend) Chats() ([]Chat, error) {
+	res, err := http.Get(w.url("/chat"))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var chats []Chat
+	if err := json.NewDecoder(res.Body).Decode(&chats); err != nil {
+		return nil, err
+	}
+	return chats, nil
+}
+
+func (w *whatshttpBackend) Messages(chatID, before string, limit int) ([]message, error) {
+	query := url.Values{}
+	query.Set("limit", strconv.Itoa(limit))
+	if before != "" {
+		query.Set("before", before)
+	}
+	res, err := http.Get(w.url("/chat/%s/messages", chatID) + "?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var msgs []message
+	if err := json.NewDecoder(res.Body).Decode(&msgs); err != nil {
+		return nil, err
+	}
+	return msgs, nil
+}
+
+func (w *whatshttpBackend) SendMessage(chatID, text, responseToID string) error {
+	data := map[string]string{"message": text}
+	if responseToID != "" {
+		data["response_to_id"] = responseToID
+	}
+	body, _ := json.Marshal(data)
+	res, err := http.Post(w.url("/chat/%s/send", chatID), "application/json", bytes.NewReader(body))
+	if err != nil {
 		return err
 	}
-	
-	if !c.Ready {
-		err = errors.New("Backend client is not ready. Please ensure WhatsApp is connected.")
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+	return nil
+}
+
+func (w *whatshttpBackend) SendMedia(chatID, mediaPath, caption, responseToID string) error {
+	// Open file
+	file, err := os.Open(mediaPath)
+	if err != nil {
 		return err
 	}
+	defer file.Close()
+
+	mimeType, err := detectContentType(file)
+	if err != nil {
+		return err
+	}
+
+	// Build multipart form
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	// Add message
+	_ = writer.WriteField("message", caption)
+
+	// Create form part with detected Content-Type
+	partHeader := textproto.MIMEHeader{}
+	partHeader.Set("Content-Disposition",
+		fmt.Sprintf(`form-data; name="media"; filename="%s"`, filepath.Base(mediaPath)))
+	partHeader.Set("Content-Type", mimeType)
+
+	part, err := writer.CreatePart(partHeader)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, file); err != nil {
+		return err
+	}
+
+	// Add response_to_id
+	_ = writer.WriteField("response_to_id", responseToID)
+
+	// Close form
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	// Prepare and send request
+	req, err := http.NewRequest("POST", w.url("/chat/%s/send", chatID), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 400 {
+		return fmt.Errorf("server error: %d", res.StatusCode)
+	}
 	return nil
 }
+
+// detectContentType sniffs the MIME type of a file from its first 512 bytes,
+// leaving it to be read from the start
+func detectContentType(file *os.File) (string, error) {
+	head := make([]byte, 512)
+	n, _ := file.Read(head)
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return http.DetectContentType(head[:n]), nil
+}
+
+func (w *whatshttpBackend) DeleteMessage(chatID, msgID string, everyone bool) error {
+	query := url.Values{}
+	query.Set("everyone", strconv.FormatBool(everyone))
+	req, err := http.NewRequest(http.MethodDelete, w.url("/message/%s", msgID)+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 400 {
+		return fmt.Errorf("failed to delete message: %s", res.Status)
+	}
+	return nil
+}
+
+func (w *whatshttpBackend) ForwardMessage(msgID, toChatID string) error {
+	res, err := http.Post(w.url("/message/%s/forward/%s", msgID, toChatID), "application/json", nil)
+	if err != nil {
+		return fmt.Errorf("failed to forward message: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to forward message: %s", res.Status)
+	}
+	return nil
+}
+
+func (w *whatshttpBackend) EditMessage(msgID, text string) error {
+	body, _ := json.Marshal(map[string]string{"message": text})
+	req, err := http.NewRequest(http.MethodPut, w.url("/message/%s", msgID), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to edit message: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 400 {
+		return fmt.Errorf("failed to edit message: %s", res.Status)
+	}
+	return nil
+}
+
+func (w *whatshttpBackend) ReactMessage(msgID, emoji string) error {
+	body, _ := json.Marshal(map[string]string{"reaction": emoji})
+	res, err := http.Post(w.url("/message/%s/react", msgID), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to react: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 400 {
+		return fmt.Errorf("failed to react: %s", res.Status)
+	}
+	return nil
+}
+
+func (w *whatshttpBackend) MediaURL(msgID string) string {
+	return w.url("/message/%s/media", msgID)
+}
+
+func (w *whatshttpBackend) Media(msgID string) (*mediaFile, error) {
+	res, err := http.Get(w.MediaURL(msgID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download media: %w", err)
+	}
+	if res.StatusCode >= 400 {
+		res.Body.Close()
+		return nil, fmt.Errorf("failed to download media: %s", res.Status)
+	}
+	media := &mediaFile{ReadCloser: res.Body, size: res.ContentLength}
+	if mimeType, _, err := mime.ParseMediaType(res.Header.Get("Content-Type")); err == nil {
+		media.mimeType = mimeType
+	}
+	if _, params, err := mime.ParseMediaType(res.Header.Get("Content-Disposition")); err == nil {
+		media.name = params["filename"]
+	}
+	return media, nil
+}